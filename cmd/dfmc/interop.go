@@ -0,0 +1,264 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+
+	tests "github.com/DFMailbox/compliance/test"
+)
+
+// InteropSchemaVersion is bumped whenever a field of InteropReport changes meaning or goes away
+const InteropSchemaVersion = 2
+
+// InteropReport holds one compliance run per ordered pair of implementations, From introducing and mailing To
+type InteropReport struct {
+	SchemaVersion   int           `json:"schema_version"`
+	Implementations []string      `json:"implementations"`
+	LabelFilter     string        `json:"label_filter"`
+	Pairs           []InteropPair `json:"pairs"`
+}
+
+type InteropPair struct {
+	From         string                    `json:"from"`
+	To           string                    `json:"to"`
+	Level        tests.ComplianceLevel     `json:"level"`
+	Passed       int                       `json:"passed"`
+	Failed       int                       `json:"failed"`
+	Skipped      int                       `json:"skipped"`
+	Requirements []tests.RequirementResult `json:"requirements"`
+	// Selected proposals, left out of the counts and the level like in the compliance report
+	Proposals []tests.RequirementResult `json:"proposals"`
+	// Why the pair has no results, e.g. a stack that never came up
+	Error string `json:"error,omitempty"`
+}
+
+// implementation is a compose file given to interop as file.yml, file.yml:service or file.yml:service:port
+type implementation struct {
+	File    string
+	Service string
+	Port    string
+}
+
+func parseImplementation(arg string) implementation {
+	parts := strings.SplitN(arg, ":", 3)
+	impl := implementation{File: parts[0]}
+	if len(parts) > 1 {
+		impl.Service = parts[1]
+	}
+	if len(parts) > 2 {
+		impl.Port = parts[2]
+	}
+	return impl
+}
+
+func interop(args []string) int {
+	env := tests.ReadEnv()
+	fs := flag.NewFlagSet("interop", flag.ExitOnError)
+	hostGateway := fs.String("host-gateway", env.HostGateway, "IP of the host machine as seen from the containers")
+	service := fs.String("service", env.Service, "compose service of every implementation that doesn't name its own")
+	port := fs.String("port", env.Port, "container port of every implementation that doesn't name its own (default the first port the service publishes)")
+	keySeed := fs.String("key-seed", env.KeySeed, "seed the test keys are derived from, the same for every pair")
+	artifacts := fs.String("artifacts", env.ArtifactsDir, "where failed specs save the logs and state of their stacks, in a directory per pair, empty to save nothing")
+	har := fs.String("har", env.HARPath, "write the exchanges of every pair to this HAR file, with the pair added to the name")
+	labelFilter := fs.String("label-filter", "cross-instance", "the specs to run for every pair")
+	output := fs.String("output", "dfmc-interop.json", "where to write the interop matrix as JSON")
+	verbose := fs.Bool("v", false, "stream the output of every run")
+	fs.Parse(args)
+	if fs.NArg() == 0 {
+		fmt.Fprintln(os.Stderr, "interop takes the compose file of every implementation, e.g. dfmc interop ours.yml theirs.yml:api:8080")
+		return 2
+	}
+	impls := []implementation{}
+	for _, arg := range fs.Args() {
+		impl := parseImplementation(arg)
+		if impl.Service == "" {
+			impl.Service = *service
+		}
+		if impl.Port == "" {
+			impl.Port = *port
+		}
+		impls = append(impls, impl)
+	}
+	self, err := os.Executable()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to find the dfmc binary: %v\n", err)
+		return 1
+	}
+	work, err := os.MkdirTemp("", "dfmc-interop")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to create a work directory: %v\n", err)
+		return 1
+	}
+	defer os.RemoveAll(work)
+
+	// Keep the specs the filter selects, a run skips every other one
+	selected := map[string]bool{}
+	for _, spec := range preview(options{labelFilter: *labelFilter}) {
+		selected[tests.RequirementOf(spec.Labels())] = true
+	}
+
+	report := InteropReport{
+		SchemaVersion:   InteropSchemaVersion,
+		Implementations: fs.Args(),
+		LabelFilter:     *labelFilter,
+		Pairs:           []InteropPair{},
+	}
+	// Ginkgo only runs specs once per process, so every pair gets its own dfmc run
+	for i, from := range impls {
+		for j, to := range impls {
+			fmt.Fprintf(os.Stderr, "Running %s -> %s\n", fs.Arg(i), fs.Arg(j))
+			name := fmt.Sprintf("%d-%d", i+1, j+1)
+			path := filepath.Join(work, name+".json")
+			pairArtifacts := ""
+			if *artifacts != "" {
+				pairArtifacts = filepath.Join(*artifacts, name)
+			}
+			pairHAR := ""
+			if *har != "" {
+				pairHAR = strings.TrimSuffix(*har, filepath.Ext(*har)) + "-" + name + filepath.Ext(*har)
+			}
+			cmd := exec.Command(self, "run",
+				"-compose", from.File,
+				"-service", from.Service,
+				"-port", from.Port,
+				"-peer-compose", to.File,
+				"-peer-service", to.Service,
+				"-peer-port", to.Port,
+				"-target=",
+				"-host-gateway", *hostGateway,
+				"-key-seed", *keySeed,
+				"-artifacts", pairArtifacts,
+				"-har", pairHAR,
+				"-label-filter", *labelFilter,
+				"-format", "json",
+				"-output", path,
+			)
+			var log bytes.Buffer
+			var out io.Writer = &log
+			if *verbose {
+				out = io.MultiWriter(&log, os.Stderr)
+			}
+			cmd.Stdout = out
+			cmd.Stderr = out
+			runErr := cmd.Run()
+
+			pair := InteropPair{From: fs.Arg(i), To: fs.Arg(j), Level: tests.ComplianceNone}
+			var compliance tests.ComplianceReport
+			data, err := os.ReadFile(path)
+			if err == nil {
+				err = json.Unmarshal(data, &compliance)
+			}
+			if err != nil {
+				pair.Error = fmt.Sprintf("no report (%v): %s", runErr, tail(log.String(), 20))
+			} else {
+				pair.grade(compliance, selected)
+			}
+			report.Pairs = append(report.Pairs, pair)
+		}
+	}
+
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err == nil {
+		err = os.WriteFile(*output, data, 0o644)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to write %s: %v\n", *output, err)
+		return 1
+	}
+	report.printMatrix(os.Stdout)
+	for _, pair := range report.Pairs {
+		if pair.Level != tests.ComplianceFull {
+			return 1
+		}
+	}
+	return 0
+}
+
+// grade keeps the selected requirements of a run and grades the pair on those alone
+func (p *InteropPair) grade(compliance tests.ComplianceReport, selected map[string]bool) {
+	p.Requirements = []tests.RequirementResult{}
+	p.Proposals = []tests.RequirementResult{}
+	for _, result := range compliance.Proposals {
+		if selected[result.Id] {
+			p.Proposals = append(p.Proposals, result)
+		}
+	}
+	for _, result := range compliance.Requirements {
+		if !selected[result.Id] {
+			continue
+		}
+		switch result.Status {
+		case tests.RequirementPassed:
+			p.Passed++
+		case tests.RequirementFailed:
+			p.Failed++
+		case tests.RequirementSkipped:
+			p.Skipped++
+		}
+		p.Requirements = append(p.Requirements, result)
+	}
+	p.Level = tests.Grade(p.Passed, p.Failed, p.Skipped)
+}
+
+func tail(s string, lines int) string {
+	split := strings.Split(strings.TrimRight(s, "\n"), "\n")
+	if len(split) > lines {
+		split = split[len(split)-lines:]
+	}
+	return strings.Join(split, "\n")
+}
+
+// printMatrix prints who can federate with whom, rows introduce and mail the columns, then every failing requirement
+func (r InteropReport) printMatrix(w io.Writer) {
+	fmt.Fprintln(w, "Implementations:")
+	for i, impl := range r.Implementations {
+		fmt.Fprintf(w, "  %d  %s\n", i+1, impl)
+	}
+	fmt.Fprintln(w)
+
+	table := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprint(table, "from \\ to")
+	for i := range r.Implementations {
+		fmt.Fprintf(table, "\t%d", i+1)
+	}
+	fmt.Fprintln(table)
+	n := len(r.Implementations)
+	for i := range r.Implementations {
+		fmt.Fprintf(table, "%d", i+1)
+		for _, pair := range r.Pairs[i*n : (i+1)*n] {
+			if pair.Error != "" {
+				fmt.Fprint(table, "\terror")
+				continue
+			}
+			fmt.Fprintf(table, "\t%s %d/%d", pair.Level, pair.Passed, len(pair.Requirements))
+		}
+		fmt.Fprintln(table)
+	}
+	table.Flush()
+
+	for k, pair := range r.Pairs {
+		from, to := k/n+1, k%n+1
+		if pair.Error != "" {
+			fmt.Fprintf(w, "\n%d -> %d did not run:\n%s\n", from, to, pair.Error)
+			continue
+		}
+		for _, req := range pair.Requirements {
+			if req.Status == tests.RequirementFailed {
+				fmt.Fprintf(w, "\n%d -> %d failed %s: %s\n%s\n", from, to, req.Id, req.Spec, tail(req.Failure, 5))
+			}
+		}
+		for _, req := range pair.Proposals {
+			if req.Status == tests.RequirementFailed {
+				fmt.Fprintf(w, "\n%d -> %d failed %s (%s): %s\n%s\n", from, to, req.Id, tests.ProposalLabel, req.Spec, tail(req.Failure, 5))
+			}
+		}
+	}
+}
@@ -0,0 +1,263 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+
+	tests "github.com/DFMailbox/compliance/test"
+	. "github.com/onsi/ginkgo/v2"
+	"github.com/onsi/ginkgo/v2/types"
+	. "github.com/onsi/gomega"
+)
+
+const usage = `dfmc runs the DFMailbox compliance suite against your implementation
+
+Usage:
+  dfmc run [flags]             run the suite
+  dfmc list [flags]            list the specs that would run
+  dfmc explain [flags] <spec>  describe every spec whose name contains <spec>
+  dfmc validate [flags]        check the compose files against the README without starting them
+  dfmc interop [flags] <compose files...>
+                               run the cross-instance specs for every ordered pair of implementations
+
+Run "dfmc <command> -h" to see the flags of a command.
+`
+
+type options struct {
+	env         tests.Environment
+	labelFilter string
+	focus       string
+	spec        string
+	format      string
+	output      string
+	verbose     bool
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+	var code int
+	switch os.Args[1] {
+	case "run":
+		opts := parseFlags("run", os.Args[2:], "text", "json", "junit")
+		code = run(opts)
+	case "list":
+		opts := parseFlags("list", os.Args[2:], "text", "json")
+		code = list(opts)
+	case "explain":
+		opts := parseFlags("explain", os.Args[2:], "text")
+		code = explain(opts)
+	case "validate":
+		opts := parseFlags("validate", os.Args[2:], "text")
+		code = validate(opts)
+	case "interop":
+		code = interop(os.Args[2:])
+	case "help", "-h", "-help", "--help":
+		fmt.Print(usage)
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown command %q\n\n%s", os.Args[1], usage)
+		code = 2
+	}
+	os.Exit(code)
+}
+
+func parseFlags(command string, args []string, formats ...string) options {
+	env := tests.ReadEnv()
+	if _, set := os.LookupEnv("DFMC_COMPOSE_FILE"); !set {
+		env.ComposePath = "compliance-docker-compose.yml"
+	}
+	var opts options
+	fs := flag.NewFlagSet(command, flag.ExitOnError)
+	fs.StringVar(&opts.env.ComposePath, "compose", env.ComposePath, "path to your compliance-docker-compose.yml")
+	fs.StringVar(&opts.env.PeerComposePath, "peer-compose", env.PeerComposePath, "compose file of the second instance in cross-instance specs (default the -compose file)")
+	fs.StringVar(&opts.env.HostGateway, "host-gateway", env.HostGateway, "IP of the host machine as seen from the containers")
+	fs.StringVar(&opts.env.TargetURL, "target", env.TargetURL, "URL of an instance you already started, compose is skipped when set")
+	fs.StringVar(&opts.env.Service, "service", env.Service, "compose service of your instance")
+	fs.StringVar(&opts.env.Port, "port", env.Port, "container port of your instance, e.g. 8080 (default the first port the service publishes)")
+	fs.StringVar(&opts.env.PeerService, "peer-service", env.PeerService, "compose service of the second instance in cross-instance specs (default -service)")
+	fs.StringVar(&opts.env.PeerPort, "peer-port", env.PeerPort, "container port of the second instance in cross-instance specs (default -port)")
+	fs.StringVar(&opts.env.KeySeed, "key-seed", env.KeySeed, "seed the test keys are derived from, pass the key_seed of a report to replay it")
+	fs.BoolVar(&opts.env.SharedStack, "shared-stack", env.SharedStack, "start one stack and reset it between Describe blocks, if the compose file declares how")
+	fs.StringVar(&opts.env.ArtifactsDir, "artifacts", env.ArtifactsDir, "where failed specs save the logs and state of their stacks, empty to save nothing")
+	fs.StringVar(&opts.env.HARPath, "har", env.HARPath, "write every request sent to an instance and its response to this HAR file")
+	fs.StringVar(&opts.labelFilter, "label-filter", "", "only run specs matching this ginkgo label filter, e.g. 'federation && !slow'")
+	fs.StringVar(&opts.focus, "focus", "", "only run specs whose name matches this regular expression")
+	fs.StringVar(&opts.format, "format", formats[0], "output format, one of "+strings.Join(formats, ", "))
+	if command == "run" {
+		fs.StringVar(&opts.output, "output", "", "where to write the json or junit compliance report (default dfmc-report.json or dfmc-report.xml)")
+		fs.BoolVar(&opts.verbose, "v", false, "print every spec as it runs")
+	}
+	fs.Parse(args)
+	opts.env.TargetURL = strings.TrimSuffix(opts.env.TargetURL, "/")
+
+	valid := false
+	for _, f := range formats {
+		valid = valid || f == opts.format
+	}
+	if !valid {
+		fmt.Fprintf(os.Stderr, "Unknown format %q, expected one of %s\n", opts.format, strings.Join(formats, ", "))
+		os.Exit(2)
+	}
+	if command == "explain" {
+		if fs.NArg() != 1 {
+			fmt.Fprintln(os.Stderr, "explain takes exactly one spec name")
+			os.Exit(2)
+		}
+		opts.spec = fs.Arg(0)
+		opts.focus = regexp.QuoteMeta(opts.spec)
+	}
+	return opts
+}
+
+func (opts options) ginkgoConfig() (types.SuiteConfig, types.ReporterConfig) {
+	suiteConfig, reporterConfig := GinkgoConfiguration()
+	suiteConfig.LabelFilter = opts.labelFilter
+	if opts.focus != "" {
+		suiteConfig.FocusStrings = []string{opts.focus}
+	}
+	reporterConfig.Verbose = opts.verbose
+	return suiteConfig, reporterConfig
+}
+
+// failureRecorder stands in for testing.T when the suite runs outside of go test
+type failureRecorder struct {
+	failed bool
+}
+
+func (t *failureRecorder) Fail() {
+	t.failed = true
+}
+
+func run(opts options) int {
+	switch opts.format {
+	case "json":
+		if opts.output == "" {
+			opts.output = "dfmc-report.json"
+		}
+		opts.env.JSONReport = opts.output
+	case "junit":
+		if opts.output == "" {
+			opts.output = "dfmc-report.xml"
+		}
+		opts.env.JUnitReport = opts.output
+	}
+	// A compose file the suite can't use fails every spec, say why once instead
+	if opts.env.TargetURL == "" && validate(opts) != 0 {
+		return 1
+	}
+	tests.Configure(opts.env)
+	suiteConfig, reporterConfig := opts.ginkgoConfig()
+
+	RegisterFailHandler(Fail)
+	var t failureRecorder
+	RunSpecs(&t, tests.SuiteDescription, suiteConfig, reporterConfig)
+	if t.failed {
+		return 1
+	}
+	return 0
+}
+
+// preview walks the spec tree without starting any stacks and returns the specs the filters select
+func preview(opts options) []types.SpecReport {
+	suiteConfig, reporterConfig := opts.ginkgoConfig()
+	report := PreviewSpecs(tests.SuiteDescription, suiteConfig, reporterConfig)
+	specs := []types.SpecReport{}
+	for _, spec := range report.SpecReports {
+		if spec.LeafNodeType == types.NodeTypeIt && !spec.State.Is(types.SpecStateSkipped) {
+			specs = append(specs, spec)
+		}
+	}
+	return specs
+}
+
+// validate checks the compose file and the peer compose file if it is another one, printing what is wrong with them
+func validate(opts options) int {
+	envs := []tests.Environment{opts.env}
+	if peer := opts.env.Peer(); peer.ComposePath != opts.env.ComposePath || peer.Service != opts.env.Service || peer.Port != opts.env.Port {
+		envs = append(envs, peer)
+	}
+	code := 0
+	for _, env := range envs {
+		err := tests.ValidateCompose(context.Background(), env.ComposePath, env)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			code = 1
+		}
+	}
+	return code
+}
+
+type listedSpec struct {
+	Requirement string   `json:"requirement,omitempty"`
+	Text        string   `json:"text"`
+	Labels      []string `json:"labels"`
+	Location    string   `json:"location"`
+}
+
+func list(opts options) int {
+	specs := preview(opts)
+	if opts.format == "json" {
+		listed := make([]listedSpec, len(specs))
+		for i, spec := range specs {
+			listed[i] = listedSpec{
+				Requirement: tests.RequirementOf(spec.Labels()),
+				Text:        spec.FullText(),
+				Labels:      spec.Labels(),
+				Location:    spec.LeafNodeLocation.String(),
+			}
+		}
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		err := enc.Encode(listed)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to encode specs: %v\n", err)
+			return 1
+		}
+		return 0
+	}
+	for _, spec := range specs {
+		if labels := spec.Labels(); len(labels) > 0 {
+			fmt.Printf("%s [%s]\n", spec.FullText(), strings.Join(labels, ", "))
+		} else {
+			fmt.Println(spec.FullText())
+		}
+	}
+	fmt.Printf("\n%d specs\n", len(specs))
+	return 0
+}
+
+func explain(opts options) int {
+	specs := preview(opts)
+	if len(specs) == 0 {
+		fmt.Fprintf(os.Stderr, "No spec matches %q, run \"dfmc list\" to see them all\n", opts.spec)
+		return 1
+	}
+	for i, spec := range specs {
+		if i > 0 {
+			fmt.Println()
+		}
+		path := append(append([]string{}, spec.ContainerHierarchyTexts...), spec.LeafNodeText)
+		fmt.Println(strings.Join(path, " > "))
+		if id := tests.RequirementOf(spec.Labels()); id != "" {
+			fmt.Printf("  Requirement: %s\n", id)
+		}
+		fmt.Printf("  Location:    %s\n", spec.LeafNodeLocation)
+		if labels := spec.Labels(); len(labels) > 0 {
+			fmt.Printf("  Labels:      %s\n", strings.Join(labels, ", "))
+		}
+		if spec.IsInOrderedContainer {
+			fmt.Println("  Ordered:     runs after the specs above it in the same container, a failure skips the rest")
+		}
+		if spec.IsSerial {
+			fmt.Println("  Serial:      never runs in parallel with other specs")
+		}
+	}
+	return 0
+}
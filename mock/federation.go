@@ -0,0 +1,233 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+)
+
+type identity struct {
+	PublicKey string `json:"public_key"`
+	Signature string `json:"signature"`
+	Address   string `json:"address"`
+}
+
+type instance struct {
+	PublicKey string  `json:"public_key"`
+	Address   *string `json:"address"`
+}
+
+// challengeBytes is what an instance signs to prove it owns an address: the address followed by the raw uuid
+func challengeBytes(address string, challenge [16]byte) []byte {
+	return append([]byte(address), challenge[:]...)
+}
+
+// compromisedBytes is what the holder of a leaked key signs to have it flagged: "compromised" followed by the raw key.
+// It does not expire, replaying it flags nothing new.
+func compromisedBytes(key []byte) []byte {
+	return append([]byte("compromised"), key...)
+}
+
+func parseUuid(str string) ([16]byte, bool) {
+	var id [16]byte
+	if len(str) != 36 || str[8] != '-' || str[13] != '-' || str[18] != '-' || str[23] != '-' {
+		return id, false
+	}
+	raw := str[0:8] + str[9:13] + str[14:18] + str[19:23] + str[24:36]
+	_, err := hex.Decode(id[:], []byte(raw))
+	return id, err == nil
+}
+
+func newUuid() [16]byte {
+	var id [16]byte
+	rand.Read(id[:])
+	id[6] = id[6]&0x0f | 0x40
+	id[8] = id[8]&0x3f | 0x80
+	return id
+}
+
+func formatUuid(id [16]byte) string {
+	h := hex.EncodeToString(id[:])
+	return fmt.Sprintf("%s-%s-%s-%s-%s", h[0:8], h[8:12], h[12:16], h[16:20], h[20:32])
+}
+
+func (s *Server) handleVerifyIdentity(w http.ResponseWriter, r *http.Request) {
+	challenge, ok := parseUuid(r.URL.Query().Get("challenge"))
+	if !ok {
+		writeProblem(w, problemBadRequest)
+		return
+	}
+	sig := ed25519.Sign(s.config.Key, challengeBytes(s.config.Address, challenge))
+	writeJSON(w, http.StatusOK, identity{
+		PublicKey: s.publicKey,
+		Signature: base64.RawStdEncoding.EncodeToString(sig),
+		Address:   s.config.Address,
+	})
+}
+
+// decodeSignature reads standard base64, padded or not. The url-safe alphabet is not standard base64.
+func decodeSignature(str string) ([]byte, bool) {
+	for _, enc := range []*base64.Encoding{base64.RawStdEncoding, base64.StdEncoding} {
+		sig, err := enc.DecodeString(str)
+		if err == nil && len(sig) == ed25519.SignatureSize {
+			return sig, true
+		}
+	}
+	return nil, false
+}
+
+// challenge asks the instance at address to prove it owns key, writing the problem if it can't
+func (s *Server) challenge(w http.ResponseWriter, key string, address string) bool {
+	challenge := newUuid()
+	target := fmt.Sprintf("http://%s/v0/federation/instance?challenge=%s", address, url.QueryEscape(formatUuid(challenge)))
+	res, err := s.client.Get(target)
+	if err != nil {
+		log.Printf("Instance %s is unreachable: %v", address, err)
+		writeProblem(w, problemInstanceUnreachable.With("address", address))
+		return false
+	}
+	defer res.Body.Close()
+
+	var body identity
+	err = json.NewDecoder(http.MaxBytesReader(nil, res.Body, 1<<16)).Decode(&body)
+	if res.StatusCode != http.StatusOK || err != nil {
+		log.Printf("Instance %s answered the challenge with %d: %v", address, res.StatusCode, err)
+		writeProblem(w, problemNonCompliance.With("address", address))
+		return false
+	}
+	sig, ok := decodeSignature(body.Signature)
+	if !ok {
+		writeProblem(w, problemNonCompliance.With("address", address))
+		return false
+	}
+
+	rawKey, _ := base64.RawURLEncoding.DecodeString(key)
+	signed := challengeBytes(address, challenge)
+	if !ed25519.Verify(rawKey, signed, sig) {
+		writeProblem(w, problemChallengeFailed.With("challenge_bytes", base64.RawStdEncoding.EncodeToString(signed)))
+		return false
+	}
+	received, ok := decodeKey(body.PublicKey)
+	if !ok || received != key {
+		writeProblem(w, problemMismatchedPublicKey.With("expected", key).With("received", body.PublicKey))
+		return false
+	}
+	if body.Address != address {
+		writeProblem(w, problemMismatchedAddress.With("expected", address).With("received", body.Address))
+		return false
+	}
+	return true
+}
+
+func (s *Server) handleIntroduceInstance(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		PublicKey string `json:"public_key"`
+		Address   string `json:"address"`
+		Update    bool   `json:"update"`
+	}
+	if !decodeBody(w, r, &req) || req.Address == "" {
+		writeProblem(w, problemBadRequest)
+		return
+	}
+	key, ok := decodeKey(req.PublicKey)
+	if !ok {
+		writeProblem(w, problemBadRequest)
+		return
+	}
+	if !s.challenge(w, key, req.Address) {
+		return
+	}
+
+	prev, exists := s.store.Instance(key)
+	if !req.Update && exists {
+		writeProblem(w, problemAlreadyExists)
+		return
+	}
+	// An update with a new key at a known address is the instance there rotating its key,
+	// the challenge above proved the address holds the new key
+	if req.Update && !exists {
+		found, free := s.store.RotateInstance(req.Address, key)
+		if !found {
+			writeProblem(w, problemNoEffectUpdate)
+			return
+		}
+		if !free {
+			writeProblem(w, problemAlreadyExists)
+			return
+		}
+		writeJSON(w, http.StatusOK, instance{PublicKey: key, Address: &req.Address})
+		return
+	}
+	if req.Update && prev == req.Address {
+		writeProblem(w, problemNoEffectUpdate)
+		return
+	}
+	s.store.PutInstance(key, req.Address)
+	writeJSON(w, http.StatusOK, instance{PublicKey: key, Address: &req.Address})
+}
+
+func (s *Server) handleLookupInstance(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	if !query.Has("public_key") {
+		writeJSON(w, http.StatusOK, map[string]instance{
+			"instance": {PublicKey: s.publicKey, Address: &s.config.Address},
+		})
+		return
+	}
+	key, ok := decodeKey(query.Get("public_key"))
+	if !ok {
+		writeProblem(w, problemBadRequest)
+		return
+	}
+	addr, ok := s.store.Instance(key)
+	if !ok {
+		writeProblem(w, problemUnknownInstance.With("public_key", key))
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]instance{
+		"instance": {PublicKey: key, Address: &addr},
+	})
+}
+
+func (s *Server) handleCompromised(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		PublicKey string `json:"public_key"`
+		Signature string `json:"signature"`
+	}
+	if !decodeBody(w, r, &req) {
+		writeProblem(w, problemBadRequest)
+		return
+	}
+	key, ok := decodeKey(req.PublicKey)
+	if !ok {
+		writeProblem(w, problemBadRequest)
+		return
+	}
+	sig, ok := decodeSignature(req.Signature)
+	if !ok {
+		writeProblem(w, problemBadRequest)
+		return
+	}
+	rawKey, _ := base64.RawURLEncoding.DecodeString(key)
+	signed := compromisedBytes(rawKey)
+	if !ed25519.Verify(rawKey, signed, sig) {
+		writeProblem(w, problemChallengeFailed.With("challenge_bytes", base64.RawStdEncoding.EncodeToString(signed)))
+		return
+	}
+	known, changed := s.store.Compromise(key)
+	if !known {
+		writeProblem(w, problemUnknownInstance.With("public_key", key))
+		return
+	}
+	if !changed {
+		writeProblem(w, problemNoEffectUpdate)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
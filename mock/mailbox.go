@@ -0,0 +1,256 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+)
+
+const (
+	defaultPollLimit = 100
+	maxPollLimit     = 1000
+)
+
+type mailboxPage struct {
+	Messages     []Message `json:"messages"`
+	MailboxMsgId int64     `json:"mailbox_msg_id"`
+}
+
+// queryInt reads an optional integer query parameter, writing a 400 if it is malformed
+func queryInt(w http.ResponseWriter, r *http.Request, name string, def int64) (int64, bool) {
+	str := r.URL.Query().Get(name)
+	if str == "" {
+		return def, true
+	}
+	n, err := strconv.ParseInt(str, 10, 64)
+	if err != nil || n < 0 {
+		writeProblem(w, problemBadRequest.With("parameter", name))
+		return 0, false
+	}
+	return n, true
+}
+
+// mailboxOwner requires the caller to be the registered plot named in the path
+func (s *Server) mailboxOwner(w http.ResponseWriter, r *http.Request) (int32, bool) {
+	plot, ok := s.registered(w, r)
+	if !ok {
+		return 0, false
+	}
+	id, err := strconv.ParseInt(r.PathValue("plot_id"), 10, 32)
+	if err != nil {
+		writeProblem(w, problemBadRequest.With("parameter", "plot_id"))
+		return 0, false
+	}
+	if int32(id) != plot.PlotId {
+		writeProblem(w, problemMailboxForbidden.With("plot_id", id))
+		return 0, false
+	}
+	return plot.PlotId, true
+}
+
+type sendRequest struct {
+	Data json.RawMessage `json:"data"`
+	// Key of the instance the recipient lives on, nil or the key of this instance for a local plot
+	PublicKey *string `json:"public_key"`
+}
+
+// federatedMessage is how an instance hands a message for one of its plots to the instance the recipient lives on
+type federatedMessage struct {
+	From Sender          `json:"from"`
+	Data json.RawMessage `json:"data"`
+}
+
+func (s *Server) handleSendMessage(w http.ResponseWriter, r *http.Request) {
+	sender, ok := s.registered(w, r)
+	if !ok {
+		return
+	}
+	id, err := strconv.ParseInt(r.PathValue("plot_id"), 10, 32)
+	if err != nil {
+		writeProblem(w, problemBadRequest.With("parameter", "plot_id"))
+		return
+	}
+	var req sendRequest
+	if !decodeBody(w, r, &req) || req.Data == nil {
+		writeProblem(w, problemBadRequest)
+		return
+	}
+	if req.PublicKey != nil {
+		key, ok := decodeKey(*req.PublicKey)
+		if !ok {
+			writeProblem(w, problemBadRequest.With("parameter", "public_key"))
+			return
+		}
+		if key != s.publicKey {
+			s.forwardMessage(w, key, id, federatedMessage{
+				From: Sender{PlotId: sender.PlotId, PublicKey: &s.publicKey},
+				Data: req.Data,
+			})
+			return
+		}
+	}
+	msgId, ok := s.store.Enqueue(int32(id), Sender{PlotId: sender.PlotId}, req.Data)
+	if !ok {
+		writeProblem(w, problemUnknownPlot.With("plot_id", id))
+		return
+	}
+	writeJSON(w, http.StatusCreated, map[string]int64{"id": msgId})
+}
+
+// forwardMessage delivers a message to a plot on another instance, relaying its answer unless it failed on its end
+func (s *Server) forwardMessage(w http.ResponseWriter, key string, plotId int64, msg federatedMessage) {
+	address, ok := s.store.Instance(key)
+	if !ok {
+		problem := problemUnknownInstance.With("public_key", key)
+		problem.Status = http.StatusConflict
+		writeProblem(w, problem)
+		return
+	}
+	body, err := json.Marshal(msg)
+	if err != nil {
+		writeProblem(w, problemBadRequest)
+		return
+	}
+	req, err := http.NewRequest(http.MethodPost, fmt.Sprintf("http://%s/v0/federation/mailbox/%d", address, plotId), bytes.NewReader(body))
+	if err != nil {
+		writeProblem(w, problemBadRequest)
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+	s.signRequest(req, body)
+	res, err := s.client.Do(req)
+	if err != nil {
+		log.Printf("Failed to deliver to %s: %v", address, err)
+		writeProblem(w, problemBadGateway)
+		return
+	}
+	defer res.Body.Close()
+	if res.StatusCode >= 500 {
+		log.Printf("Instance %s failed to accept a message with %d", address, res.StatusCode)
+		writeProblem(w, problemBadGateway)
+		return
+	}
+	w.Header().Set("Content-Type", res.Header.Get("Content-Type"))
+	w.WriteHeader(res.StatusCode)
+	io.Copy(w, io.LimitReader(res.Body, 1<<16))
+}
+
+func (s *Server) handleFederatedMessage(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(r.PathValue("plot_id"), 10, 32)
+	if err != nil {
+		writeProblem(w, problemBadRequest.With("parameter", "plot_id"))
+		return
+	}
+	key, ok := s.verifyRequest(w, r)
+	if !ok {
+		return
+	}
+	var msg federatedMessage
+	if !decodeBody(w, r, &msg) || msg.Data == nil || msg.From.PublicKey == nil {
+		writeProblem(w, problemBadRequest)
+		return
+	}
+	// The sender can only speak for its own plots
+	if from, ok := decodeKey(*msg.From.PublicKey); !ok || from != key {
+		writeProblem(w, problemBadRequest.With("parameter", "public_key"))
+		return
+	}
+	msgId, ok := s.store.Enqueue(int32(id), Sender{PlotId: msg.From.PlotId, PublicKey: &key}, msg.Data)
+	if !ok {
+		writeProblem(w, problemUnknownPlot.With("plot_id", id))
+		return
+	}
+	writeJSON(w, http.StatusCreated, map[string]int64{"id": msgId})
+}
+
+func (s *Server) handleReadMailbox(w http.ResponseWriter, r *http.Request) {
+	id, ok := s.mailboxOwner(w, r)
+	if !ok {
+		return
+	}
+	after, ok := queryInt(w, r, "after", 0)
+	if !ok {
+		return
+	}
+	limit, ok := queryInt(w, r, "limit", defaultPollLimit)
+	if !ok {
+		return
+	}
+	if limit == 0 || limit > maxPollLimit {
+		writeProblem(w, problemBadRequest.With("parameter", "limit"))
+		return
+	}
+	msgs, last, ok := s.store.Messages(id, after, int(limit))
+	if !ok {
+		writeProblem(w, problemUnknownPlot.With("plot_id", id))
+		return
+	}
+	writeJSON(w, http.StatusOK, mailboxPage{Messages: msgs, MailboxMsgId: last})
+}
+
+func (s *Server) handleAcknowledge(w http.ResponseWriter, r *http.Request) {
+	id, ok := s.mailboxOwner(w, r)
+	if !ok {
+		return
+	}
+	if !r.URL.Query().Has("until") {
+		writeProblem(w, problemBadRequest.With("parameter", "until"))
+		return
+	}
+	until, ok := queryInt(w, r, "until", 0)
+	if !ok {
+		return
+	}
+	if _, ok := s.store.Acknowledge(id, until); !ok {
+		writeProblem(w, problemUnknownPlot.With("plot_id", id))
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// messageId parses the message id in the path, writing a 400 if it is malformed
+func messageId(w http.ResponseWriter, r *http.Request) (int64, bool) {
+	msgId, err := strconv.ParseInt(r.PathValue("msg_id"), 10, 64)
+	if err != nil {
+		writeProblem(w, problemBadRequest.With("parameter", "msg_id"))
+		return 0, false
+	}
+	return msgId, true
+}
+
+func (s *Server) handleReadMessage(w http.ResponseWriter, r *http.Request) {
+	id, ok := s.mailboxOwner(w, r)
+	if !ok {
+		return
+	}
+	msgId, ok := messageId(w, r)
+	if !ok {
+		return
+	}
+	msg, ok := s.store.Message(id, msgId)
+	if !ok {
+		writeProblem(w, problemNotFound)
+		return
+	}
+	writeJSON(w, http.StatusOK, msg)
+}
+
+func (s *Server) handleDeleteMessage(w http.ResponseWriter, r *http.Request) {
+	id, ok := s.mailboxOwner(w, r)
+	if !ok {
+		return
+	}
+	msgId, ok := messageId(w, r)
+	if !ok {
+		return
+	}
+	if !s.store.DeleteMessage(id, msgId) {
+		writeProblem(w, problemNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
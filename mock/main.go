@@ -1,5 +1,62 @@
 package main
 
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+)
+
 func main() {
-	println("Hello world")
+	config, err := readConfig()
+	if err != nil {
+		log.Fatalf("Invalid configuration: %v", err)
+	}
+	server := NewServer(config)
+	log.Printf("Serving %s as %s on :%s", config.Address, server.publicKey, config.Port)
+	err = http.ListenAndServe(":"+config.Port, server)
+	if err != nil {
+		log.Fatalf("Server stopped: %v", err)
+	}
+}
+
+// Config mirrors the environment a compliance-docker-compose.yml passes to the instance
+type Config struct {
+	Address   string
+	Key       ed25519.PrivateKey
+	Port      string
+	MojangAPI string
+	// Where POST wipes every instance, plot and message, disabled when empty
+	ResetPath string
+}
+
+func readConfig() (Config, error) {
+	address := os.Getenv("HOST")
+	if address == "" {
+		return Config{}, fmt.Errorf("HOST is not set")
+	}
+	seed, err := base64.StdEncoding.DecodeString(os.Getenv("SECRET_KEY"))
+	if err != nil {
+		return Config{}, fmt.Errorf("SECRET_KEY is not valid base64: %v", err)
+	}
+	if len(seed) != ed25519.SeedSize {
+		return Config{}, fmt.Errorf("SECRET_KEY is %d bytes, expected a %d byte seed", len(seed), ed25519.SeedSize)
+	}
+	port := os.Getenv("PORT")
+	if port == "" {
+		port = "8080"
+	}
+	mojang := os.Getenv("MOJANG_API")
+	if mojang == "" {
+		mojang = "https://api.mojang.com"
+	}
+	return Config{
+		Address:   address,
+		Key:       ed25519.NewKeyFromSeed(seed),
+		Port:      port,
+		MojangAPI: mojang,
+		ResetPath: os.Getenv("RESET_PATH"),
+	}, nil
 }
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"log"
+	"net/http"
+)
+
+type plotRequest struct {
+	PublicKey *string `json:"public_key"`
+}
+
+// instanceKey validates the instance a plot wants to belong to. A nil key means this instance.
+func (s *Server) instanceKey(w http.ResponseWriter, r *http.Request) (*string, bool) {
+	var req plotRequest
+	if !decodeBody(w, r, &req) {
+		writeProblem(w, problemBadRequest)
+		return nil, false
+	}
+	if req.PublicKey == nil {
+		return nil, true
+	}
+	key, ok := decodeKey(*req.PublicKey)
+	if !ok {
+		writeProblem(w, problemBadRequest)
+		return nil, false
+	}
+	if _, ok := s.store.Instance(key); !ok {
+		problem := problemUnknownInstance.With("public_key", key)
+		problem.Status = http.StatusConflict
+		writeProblem(w, problem)
+		return nil, false
+	}
+	if s.store.Compromised(key) {
+		writeProblem(w, problemKeyCompromised.With("public_key", key))
+		return nil, false
+	}
+	return &key, true
+}
+
+func (s *Server) handleRegisterPlot(w http.ResponseWriter, r *http.Request) {
+	auth, ok := s.authenticate(w, r)
+	if !ok {
+		return
+	}
+	key, ok := s.instanceKey(w, r)
+	if !ok {
+		return
+	}
+	owner, err := s.ownerUuid(auth.Owner)
+	if err != nil {
+		log.Printf("Failed to look up %s: %v", auth.Owner, err)
+		writeProblem(w, problemBadGateway)
+		return
+	}
+	if !s.store.CreatePlot(auth.PlotId, owner, key) {
+		writeProblem(w, problemAlreadyExists)
+		return
+	}
+	w.WriteHeader(http.StatusCreated)
+}
+
+func (s *Server) handleGetPlot(w http.ResponseWriter, r *http.Request) {
+	plot, ok := s.registered(w, r)
+	if !ok {
+		return
+	}
+	writeJSON(w, http.StatusOK, plot)
+}
+
+func (s *Server) handleUpdatePlot(w http.ResponseWriter, r *http.Request) {
+	plot, ok := s.registered(w, r)
+	if !ok {
+		return
+	}
+	key, ok := s.instanceKey(w, r)
+	if !ok {
+		return
+	}
+	found, changed := s.store.MovePlot(plot.PlotId, key)
+	if !found {
+		writeProblem(w, problemUnknownPlot.With("plot_id", plot.PlotId))
+		return
+	}
+	if !changed {
+		writeProblem(w, problemNoEffectUpdate)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+func (s *Server) handleDeletePlot(w http.ResponseWriter, r *http.Request) {
+	auth, ok := s.authenticate(w, r)
+	if !ok {
+		return
+	}
+	plot, ok := s.store.Plot(auth.PlotId)
+	if !ok {
+		writeProblem(w, problemUnknownPlot.With("plot_id", auth.PlotId))
+		return
+	}
+	owner, err := s.ownerUuid(auth.Owner)
+	if err != nil {
+		log.Printf("Failed to look up %s: %v", auth.Owner, err)
+		writeProblem(w, problemBadGateway)
+		return
+	}
+	if owner != plot.Owner {
+		writeProblem(w, problemPlotForbidden.With("plot_id", auth.PlotId))
+		return
+	}
+	s.store.DeletePlot(auth.PlotId)
+	w.WriteHeader(http.StatusNoContent)
+}
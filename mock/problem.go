@@ -0,0 +1,84 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+)
+
+// Problem is an RFC 9457 problem details body. Extension members are flattened next to the standard ones.
+type Problem struct {
+	Type       string
+	Title      string
+	Status     int
+	Extensions map[string]any
+}
+
+func (p Problem) MarshalJSON() ([]byte, error) {
+	body := make(map[string]any, len(p.Extensions)+3)
+	for k, v := range p.Extensions {
+		body[k] = v
+	}
+	body["type"] = p.Type
+	body["title"] = p.Title
+	body["status"] = p.Status
+	return json.Marshal(body)
+}
+
+// With returns a copy of the problem with an extension member added
+func (p Problem) With(key string, value any) Problem {
+	ext := make(map[string]any, len(p.Extensions)+1)
+	for k, v := range p.Extensions {
+		ext[k] = v
+	}
+	ext[key] = value
+	p.Extensions = ext
+	return p
+}
+
+var (
+	problemBadRequest          = Problem{Type: "https://tools.ietf.org/html/rfc9110#section-15.5.1", Title: "Bad Request", Status: 400}
+	problemUnauthorized        = Problem{Type: "https://tools.ietf.org/html/rfc9110#section-15.5.2", Title: "Unauthorized", Status: 401}
+	problemBadGateway          = Problem{Type: "https://tools.ietf.org/html/rfc9110#section-15.6.3", Title: "Bad Gateway", Status: 502}
+	problemNotFound            = Problem{Type: "https://tools.ietf.org/html/rfc9110#section-15.5.5", Title: "Not Found", Status: 404}
+	problemAlreadyExists       = Problem{Type: "/v0/problems/already-exists", Title: "The resource being created already exists", Status: 409}
+	problemNoEffectUpdate      = Problem{Type: "/v0/problems/no-effect-update", Title: "The update had no effect", Status: 409}
+	problemUnknownInstance     = Problem{Type: "/v0/problems/unknown-instance", Title: "Specified instance has not been identified", Status: 404}
+	problemChallengeFailed     = Problem{Type: "/v0/problems/challenge-failed", Title: "Invalid challenge signature", Status: 400}
+	problemInstanceUnreachable = Problem{Type: "/v0/problems/federation/instance-unreachable", Title: "Cannot reach the introduced instance", Status: 400}
+	problemNonCompliance       = Problem{Type: "/v0/problems/federation/non-compliance", Title: "The introduced instance is not compliant", Status: 400}
+	problemMismatchedAddress   = Problem{Type: "/v0/problems/instance-introduction/mismatched-address", Title: "The introduced instance reported a different address", Status: 400}
+	problemMismatchedPublicKey = Problem{Type: "/v0/problems/instance-introduction/mismatched-public-key", Title: "The introduced instance reported a different public key", Status: 400}
+	problemExpectedRoleAny     = Problem{Type: "/v0/problems/expected-role/any", Title: "Expected any registration", Status: 403}
+	problemUnknownPlot         = Problem{Type: "/v0/problems/unknown-plot", Title: "Specified plot is not registered", Status: 404}
+	problemMailboxForbidden    = Problem{Type: "/v0/problems/mailbox/forbidden", Title: "Cannot access the mailbox of another plot", Status: 403}
+	problemPlotForbidden       = Problem{Type: "/v0/problems/plot/forbidden", Title: "Only the owner of a plot can delete it", Status: 403}
+	problemKeyCompromised      = Problem{Type: "/v0/problems/instance-key-compromised", Title: "The instance key has been compromised", Status: 409}
+	problemInvalidSignature    = Problem{Type: "/v0/problems/federation/invalid-signature", Title: "The request signature is invalid", Status: 401}
+	problemExpiredRequest      = Problem{Type: "/v0/problems/federation/expired-request", Title: "The request timestamp is outside the accepted window", Status: 401}
+	problemReplayedNonce       = Problem{Type: "/v0/problems/federation/replayed-nonce", Title: "The request nonce was already used", Status: 409}
+)
+
+func writeProblem(w http.ResponseWriter, p Problem) {
+	body, err := json.Marshal(p)
+	if err != nil {
+		log.Printf("Failed to encode problem %s: %v", p.Type, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/problem+json; charset=utf-8")
+	w.WriteHeader(p.Status)
+	w.Write(body)
+}
+
+func writeJSON(w http.ResponseWriter, status int, value any) {
+	body, err := json.Marshal(value)
+	if err != nil {
+		log.Printf("Failed to encode response: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	w.Write(body)
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Server is an in-memory DFMailbox instance that follows the protocol the compliance suite checks
+type Server struct {
+	config    Config
+	publicKey string
+	store     *Store
+	client    *http.Client
+	mux       *http.ServeMux
+
+	ownersMu sync.Mutex
+	owners   map[string]string
+}
+
+func NewServer(config Config) *Server {
+	s := &Server{
+		config:    config,
+		publicKey: encodeKey(config.Key.Public().(ed25519.PublicKey)),
+		store:     NewStore(),
+		client: &http.Client{
+			Timeout: 10 * time.Second,
+			// A peer redirecting its challenge elsewhere is not compliant
+			CheckRedirect: func(req *http.Request, via []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+		mux:    http.NewServeMux(),
+		owners: map[string]string{},
+	}
+	s.mux.HandleFunc("GET /{$}", s.handleRoot)
+	s.mux.HandleFunc("GET /v0/federation/instance", s.handleVerifyIdentity)
+	s.mux.HandleFunc("POST /v0/federation/mailbox/{plot_id}", s.handleFederatedMessage)
+	s.mux.HandleFunc("POST /v0/instance", s.handleIntroduceInstance)
+	s.mux.HandleFunc("GET /v0/instance", s.handleLookupInstance)
+	s.mux.HandleFunc("POST /v0/instance/compromised", s.handleCompromised)
+	s.mux.HandleFunc("POST /v0/plot", s.handleRegisterPlot)
+	s.mux.HandleFunc("GET /v0/plot", s.handleGetPlot)
+	s.mux.HandleFunc("PUT /v0/plot", s.handleUpdatePlot)
+	s.mux.HandleFunc("DELETE /v0/plot", s.handleDeletePlot)
+	s.mux.HandleFunc("POST /v0/mailbox/{plot_id}", s.handleSendMessage)
+	s.mux.HandleFunc("GET /v0/mailbox/{plot_id}", s.handleReadMailbox)
+	s.mux.HandleFunc("DELETE /v0/mailbox/{plot_id}", s.handleAcknowledge)
+	s.mux.HandleFunc("GET /v0/mailbox/{plot_id}/{msg_id}", s.handleReadMessage)
+	s.mux.HandleFunc("DELETE /v0/mailbox/{plot_id}/{msg_id}", s.handleDeleteMessage)
+	if config.ResetPath != "" {
+		s.mux.HandleFunc("POST "+config.ResetPath, s.handleReset)
+	}
+	return s
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
+
+func (s *Server) handleRoot(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Write([]byte("dfmailbox"))
+}
+
+// handleReset lets the suite share one mock between Describe blocks, it is not part of the protocol
+func (s *Server) handleReset(w http.ResponseWriter, r *http.Request) {
+	s.store.Reset()
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// encodeKey is the canonical form of a public key: url-safe base64 without padding
+func encodeKey(key ed25519.PublicKey) string {
+	return base64.RawURLEncoding.EncodeToString(key)
+}
+
+// decodeKey accepts any base64 alphabet, padded or not, and returns the canonical form
+func decodeKey(str string) (string, bool) {
+	for _, enc := range []*base64.Encoding{
+		base64.RawURLEncoding, base64.URLEncoding, base64.StdEncoding, base64.RawStdEncoding,
+	} {
+		key, err := enc.DecodeString(str)
+		if err == nil && len(key) == ed25519.PublicKeySize {
+			return encodeKey(key), true
+		}
+	}
+	return "", false
+}
+
+func decodeBody(w http.ResponseWriter, r *http.Request, v any) bool {
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20))
+	return dec.Decode(v) == nil
+}
+
+var userAgentRegex = regexp.MustCompile(`^Hypercube/\S+ \((\d+), (\w{1,16})\)$`)
+
+// PlotAuth is the identity DiamondFire attaches to every request a plot makes
+type PlotAuth struct {
+	PlotId int32
+	Owner  string
+}
+
+// authenticate reads the plot out of the user agent, writing a 401 if there is none
+func (s *Server) authenticate(w http.ResponseWriter, r *http.Request) (PlotAuth, bool) {
+	match := userAgentRegex.FindStringSubmatch(r.UserAgent())
+	if match == nil {
+		writeProblem(w, problemUnauthorized)
+		return PlotAuth{}, false
+	}
+	id, err := strconv.ParseInt(match[1], 10, 32)
+	if err != nil {
+		writeProblem(w, problemUnauthorized)
+		return PlotAuth{}, false
+	}
+	return PlotAuth{PlotId: int32(id), Owner: match[2]}, true
+}
+
+// registered authenticates the plot and requires it to be registered in any role
+func (s *Server) registered(w http.ResponseWriter, r *http.Request) (PlotInfo, bool) {
+	auth, ok := s.authenticate(w, r)
+	if !ok {
+		return PlotInfo{}, false
+	}
+	plot, ok := s.store.Plot(auth.PlotId)
+	if !ok {
+		writeProblem(w, problemExpectedRoleAny.
+			With("expected", []string{"host", "registered"}).
+			With("received", "unregistered"))
+		return PlotInfo{}, false
+	}
+	return plot, true
+}
+
+// ownerUuid resolves a player name through the Mojang API, caching the answer
+func (s *Server) ownerUuid(name string) (string, error) {
+	lower := strings.ToLower(name)
+	s.ownersMu.Lock()
+	id, ok := s.owners[lower]
+	s.ownersMu.Unlock()
+	if ok {
+		return id, nil
+	}
+	res, err := s.client.Get(fmt.Sprintf("%s/users/profiles/minecraft/%s", s.config.MojangAPI, name))
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("mojang responded with %d", res.StatusCode)
+	}
+	var profile struct {
+		Id string `json:"id"`
+	}
+	err = json.NewDecoder(res.Body).Decode(&profile)
+	if err != nil {
+		return "", err
+	}
+	if len(profile.Id) != 32 {
+		return "", fmt.Errorf("mojang returned malformed id %q", profile.Id)
+	}
+	p := profile.Id
+	id = fmt.Sprintf("%s-%s-%s-%s-%s", p[0:8], p[8:12], p[12:16], p[16:20], p[20:32])
+	s.ownersMu.Lock()
+	s.owners[lower] = id
+	s.ownersMu.Unlock()
+	return id, nil
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Headers every request on /v0/federation/* except the identity challenge carries
+const (
+	headerPublicKey = "Dfm-Public-Key"
+	headerTimestamp = "Dfm-Timestamp"
+	headerNonce     = "Dfm-Nonce"
+	headerSignature = "Dfm-Signature"
+)
+
+// requestWindow is how far a request timestamp may be from now, a nonce is remembered for twice as long
+const requestWindow = 5 * time.Minute
+
+// requestBytes is what an instance signs to authenticate a federation request
+func requestBytes(method string, uri string, timestamp int64, nonce string, body []byte) []byte {
+	signed := fmt.Appendf(nil, "%s %s\n%d\n%s\n", method, uri, timestamp, nonce)
+	return append(signed, body...)
+}
+
+// signRequest authenticates a request to another instance as this one
+func (s *Server) signRequest(req *http.Request, body []byte) {
+	timestamp := time.Now().Unix()
+	nonce := formatUuid(newUuid())
+	sig := ed25519.Sign(s.config.Key, requestBytes(req.Method, req.URL.RequestURI(), timestamp, nonce, body))
+	req.Header.Set(headerPublicKey, s.publicKey)
+	req.Header.Set(headerTimestamp, strconv.FormatInt(timestamp, 10))
+	req.Header.Set(headerNonce, nonce)
+	req.Header.Set(headerSignature, base64.RawStdEncoding.EncodeToString(sig))
+}
+
+// verifyRequest checks that an identified instance sent the request and hasn't sent it before, writing the problem if not.
+// The body stays readable.
+func (s *Server) verifyRequest(w http.ResponseWriter, r *http.Request) (string, bool) {
+	key, ok := decodeKey(r.Header.Get(headerPublicKey))
+	if !ok {
+		writeProblem(w, problemUnauthorized)
+		return "", false
+	}
+	timestamp, err := strconv.ParseInt(r.Header.Get(headerTimestamp), 10, 64)
+	if err != nil {
+		writeProblem(w, problemUnauthorized)
+		return "", false
+	}
+	nonce := r.Header.Get(headerNonce)
+	parsed, ok := parseUuid(nonce)
+	if !ok {
+		writeProblem(w, problemUnauthorized)
+		return "", false
+	}
+	sig, ok := decodeSignature(r.Header.Get(headerSignature))
+	if !ok {
+		writeProblem(w, problemUnauthorized)
+		return "", false
+	}
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, 1<<20))
+	if err != nil {
+		writeProblem(w, problemBadRequest)
+		return "", false
+	}
+	r.Body = io.NopCloser(bytes.NewReader(body))
+
+	sent := time.Unix(timestamp, 0)
+	if time.Since(sent).Abs() > requestWindow {
+		writeProblem(w, problemExpiredRequest.With("timestamp", timestamp))
+		return "", false
+	}
+	rawKey, _ := base64.RawURLEncoding.DecodeString(key)
+	signed := requestBytes(r.Method, r.URL.RequestURI(), timestamp, nonce, body)
+	if !ed25519.Verify(rawKey, signed, sig) {
+		writeProblem(w, problemInvalidSignature.With("signed_bytes", base64.RawStdEncoding.EncodeToString(signed)))
+		return "", false
+	}
+	if _, ok := s.store.Instance(key); !ok {
+		problem := problemUnknownInstance.With("public_key", key)
+		problem.Status = http.StatusConflict
+		writeProblem(w, problem)
+		return "", false
+	}
+	if !s.store.UseNonce(key, parsed, sent.Add(2*requestWindow)) {
+		writeProblem(w, problemReplayedNonce.With("nonce", nonce))
+		return "", false
+	}
+	return key, true
+}
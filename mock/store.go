@@ -0,0 +1,306 @@
+package main
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+)
+
+// Store holds every piece of state the mock has. Nothing survives a restart.
+type Store struct {
+	mu        sync.Mutex
+	instances map[string]string
+	// Keys whose private half leaked, plots can no longer be bound to them
+	compromised map[string]bool
+	plots       map[int32]*plotRecord
+	// Nonces of signed federation requests, kept until the request would have expired anyway
+	nonces map[nonceKey]time.Time
+}
+
+type nonceKey struct {
+	key   string
+	nonce [16]byte
+}
+
+type plotRecord struct {
+	id      int32
+	owner   string
+	key     *string
+	lastMsg int64
+	mailbox []Message
+}
+
+type Message struct {
+	Id     int64           `json:"id"`
+	From   Sender          `json:"from"`
+	Data   json.RawMessage `json:"data"`
+	SentAt time.Time       `json:"sent_at"`
+}
+
+type Sender struct {
+	PlotId    int32   `json:"plot_id"`
+	PublicKey *string `json:"public_key"`
+}
+
+type PlotInfo struct {
+	PlotId       int32   `json:"plot_id"`
+	Owner        string  `json:"owner"`
+	PublicKey    *string `json:"public_key"`
+	Address      *string `json:"address"`
+	MailboxMsgId int64   `json:"mailbox_msg_id"`
+}
+
+func NewStore() *Store {
+	return &Store{
+		instances:   map[string]string{},
+		compromised: map[string]bool{},
+		plots:       map[int32]*plotRecord{},
+		nonces:      map[nonceKey]time.Time{},
+	}
+}
+
+// Reset forgets everything, as if the mock was restarted
+func (s *Store) Reset() {
+	fresh := NewStore()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.instances = fresh.instances
+	s.compromised = fresh.compromised
+	s.plots = fresh.plots
+	s.nonces = fresh.nonces
+}
+
+func (s *Store) Instance(key string) (string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	addr, ok := s.instances[key]
+	return addr, ok
+}
+
+// PutInstance stores the address of an instance, returning whether it already existed and its previous address
+func (s *Store) PutInstance(key string, address string) (string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	prev, ok := s.instances[key]
+	s.instances[key] = address
+	return prev, ok
+}
+
+// Compromise flags the key of a known instance, returning whether it is known and whether the flag is new
+func (s *Store) Compromise(key string) (bool, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.instances[key]; !ok {
+		return false, false
+	}
+	if s.compromised[key] {
+		return true, false
+	}
+	s.compromised[key] = true
+	return true, true
+}
+
+// RotateInstance moves the instance at address and every plot bound to it to a new key,
+// returning whether an instance is at the address and whether the new key is still free
+func (s *Store) RotateInstance(address string, newKey string) (bool, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.instances[newKey]; ok {
+		return true, false
+	}
+	rotated := map[string]bool{}
+	for key, addr := range s.instances {
+		if addr == address {
+			rotated[key] = true
+		}
+	}
+	if len(rotated) == 0 {
+		return false, true
+	}
+	for key := range rotated {
+		delete(s.instances, key)
+	}
+	s.instances[newKey] = address
+	for _, plot := range s.plots {
+		if plot.key != nil && rotated[*plot.key] {
+			plot.key = &newKey
+		}
+	}
+	return true, true
+}
+
+func (s *Store) Compromised(key string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.compromised[key]
+}
+
+// UseNonce remembers a nonce of key until expires, returning false if it was already used
+func (s *Store) UseNonce(key string, nonce [16]byte, expires time.Time) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	for k, until := range s.nonces {
+		if now.After(until) {
+			delete(s.nonces, k)
+		}
+	}
+	k := nonceKey{key: key, nonce: nonce}
+	if _, ok := s.nonces[k]; ok {
+		return false
+	}
+	s.nonces[k] = expires
+	return true
+}
+
+func (s *Store) Plot(id int32) (PlotInfo, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	plot, ok := s.plots[id]
+	if !ok {
+		return PlotInfo{}, false
+	}
+	return s.plotInfo(plot), true
+}
+
+func (s *Store) plotInfo(plot *plotRecord) PlotInfo {
+	info := PlotInfo{
+		PlotId:       plot.id,
+		Owner:        plot.owner,
+		MailboxMsgId: plot.lastMsg,
+	}
+	if plot.key != nil {
+		key := *plot.key
+		addr := s.instances[key]
+		info.PublicKey = &key
+		info.Address = &addr
+	}
+	return info
+}
+
+// CreatePlot registers a plot, returning false if it was already registered
+func (s *Store) CreatePlot(id int32, owner string, key *string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.plots[id]; ok {
+		return false
+	}
+	s.plots[id] = &plotRecord{id: id, owner: owner, key: key}
+	return true
+}
+
+// DeletePlot drops a plot along with its mailbox, returning false if it was not registered
+func (s *Store) DeletePlot(id int32) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.plots[id]; !ok {
+		return false
+	}
+	delete(s.plots, id)
+	return true
+}
+
+// MovePlot changes the instance a plot belongs to, returning whether it is registered and whether anything changed
+func (s *Store) MovePlot(id int32, key *string) (bool, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	plot, ok := s.plots[id]
+	if !ok {
+		return false, false
+	}
+	if plot.key == nil && key == nil || plot.key != nil && key != nil && *plot.key == *key {
+		return true, false
+	}
+	plot.key = key
+	return true, true
+}
+
+// Enqueue appends a message to a plot's mailbox and returns its id
+func (s *Store) Enqueue(id int32, from Sender, data json.RawMessage) (int64, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	plot, ok := s.plots[id]
+	if !ok {
+		return 0, false
+	}
+	plot.lastMsg++
+	plot.mailbox = append(plot.mailbox, Message{
+		Id:     plot.lastMsg,
+		From:   from,
+		Data:   data,
+		SentAt: time.Now().UTC(),
+	})
+	return plot.lastMsg, true
+}
+
+// Messages returns up to limit messages with an id greater than after, oldest first, and false if the plot is not registered
+func (s *Store) Messages(id int32, after int64, limit int) ([]Message, int64, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	plot, ok := s.plots[id]
+	if !ok {
+		return nil, 0, false
+	}
+	msgs := []Message{}
+	for _, msg := range plot.mailbox {
+		if len(msgs) == limit {
+			break
+		}
+		if msg.Id > after {
+			msgs = append(msgs, msg)
+		}
+	}
+	return msgs, plot.lastMsg, true
+}
+
+// Message returns a single message, and false if it or its plot does not exist
+func (s *Store) Message(id int32, msgId int64) (Message, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	plot, ok := s.plots[id]
+	if !ok {
+		return Message{}, false
+	}
+	for _, msg := range plot.mailbox {
+		if msg.Id == msgId {
+			return msg, true
+		}
+	}
+	return Message{}, false
+}
+
+// Acknowledge drops every message with an id up to and including until, returning how many were removed and false if the plot is not registered
+func (s *Store) Acknowledge(id int32, until int64) (int, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	plot, ok := s.plots[id]
+	if !ok {
+		return 0, false
+	}
+	kept := plot.mailbox[:0]
+	for _, msg := range plot.mailbox {
+		if msg.Id > until {
+			kept = append(kept, msg)
+		}
+	}
+	removed := len(plot.mailbox) - len(kept)
+	plot.mailbox = kept
+	return removed, true
+}
+
+// DeleteMessage drops a single message, returning false if it or its plot did not exist
+func (s *Store) DeleteMessage(id int32, msgId int64) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	plot, ok := s.plots[id]
+	if !ok {
+		return false
+	}
+	for i, msg := range plot.mailbox {
+		if msg.Id == msgId {
+			plot.mailbox = append(plot.mailbox[:i], plot.mailbox[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
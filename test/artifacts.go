@@ -0,0 +1,199 @@
+package tests
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/filters"
+	. "github.com/onsi/ginkgo/v2"
+	"github.com/onsi/ginkgo/v2/types"
+	"github.com/testcontainers/testcontainers-go"
+)
+
+// DefaultArtifactsDir is where the artifacts of failed specs go unless DFMC_ARTIFACTS_DIR says otherwise
+const DefaultArtifactsDir = "dfmc-artifacts"
+
+// Labels compose puts on every container it starts
+const (
+	composeProjectLabel = "com.docker.compose.project"
+	composeServiceLabel = "com.docker.compose.service"
+)
+
+// liveStacks are the stacks of this process that are up, a failed spec saves every one of them
+var liveStacks = struct {
+	sync.Mutex
+	targets []*Target
+	// Stacks torn down while a spec was failing, saved before they were gone
+	snapshots map[string]map[string][]byte
+}{snapshots: map[string]map[string][]byte{}}
+
+func trackStack(target *Target) {
+	liveStacks.Lock()
+	defer liveStacks.Unlock()
+	liveStacks.targets = append(liveStacks.targets, target)
+}
+
+// untrackStack forgets a stack about to be torn down, holding on to what it looked like if the current spec failed
+func untrackStack(target *Target) {
+	var snapshot map[string][]byte
+	if CurrentSpecReport().Failed() && CurrentEnv().ArtifactsDir != "" {
+		snapshot = target.snapshot()
+	}
+	liveStacks.Lock()
+	defer liveStacks.Unlock()
+	liveStacks.targets = slices.DeleteFunc(liveStacks.targets, func(t *Target) bool { return t == target })
+	if snapshot != nil {
+		liveStacks.snapshots[target.Project] = snapshot
+	}
+}
+
+// takeSnapshots returns the held snapshots along with ones of every stack still up, live is false to only drop the held ones
+func takeSnapshots(live bool) map[string]map[string][]byte {
+	liveStacks.Lock()
+	snapshots := liveStacks.snapshots
+	targets := slices.Clone(liveStacks.targets)
+	liveStacks.snapshots = map[string]map[string][]byte{}
+	liveStacks.Unlock()
+	if !live {
+		return nil
+	}
+	for _, target := range targets {
+		snapshots[target.Project] = target.snapshot()
+	}
+	return snapshots
+}
+
+// snapshot reads the logs and docker inspect of every container in the project, keyed by the file they are saved to.
+// It asks docker rather than the compose handle, which knows nothing of a stack that failed to come up or another process started.
+func (t *Target) snapshot() map[string][]byte {
+	files := map[string][]byte{}
+	fail := func(format string, args ...any) map[string][]byte {
+		files["error.txt"] = fmt.Appendf(files["error.txt"], format+"\n", args...)
+		return files
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	provider, err := testcontainers.NewDockerProvider()
+	if err != nil {
+		return fail("Failed to connect to docker %v", err)
+	}
+	defer provider.Close()
+	containers, err := provider.Client().ContainerList(ctx, container.ListOptions{
+		All:     true,
+		Filters: filters.NewArgs(filters.Arg("label", composeProjectLabel+"="+t.Project)),
+	})
+	if err != nil {
+		return fail("Failed to list the containers of %s %v", t.Project, err)
+	}
+	for _, summary := range containers {
+		service := summary.Labels[composeServiceLabel]
+		ctr, err := provider.ContainerFromType(ctx, summary)
+		if err != nil {
+			fail("Failed to find %s %v", service, err)
+			continue
+		}
+		info, err := ctr.Inspect(ctx)
+		if err != nil {
+			fail("Failed to inspect %s %v", service, err)
+		} else {
+			files[service+".inspect.json"], _ = json.MarshalIndent(info, "", "  ")
+		}
+		logs, err := ctr.Logs(ctx)
+		if err != nil {
+			fail("Failed to read the logs of %s %v", service, err)
+			continue
+		}
+		files[service+".log"], _ = io.ReadAll(logs)
+		logs.Close()
+	}
+	return files
+}
+
+// ArtifactsDirOf is where the artifacts of a spec are saved, the same in every process so the report can find them
+func ArtifactsDirOf(root string, spec types.SpecReport) string {
+	name := RequirementOf(spec.Labels())
+	if name == "" {
+		name = slug(spec.FullText(), 64)
+	}
+	return filepath.Join(root, name)
+}
+
+// ArtifactsOf lists the artifacts saved for a spec, as absolute paths
+func ArtifactsOf(root string, spec types.SpecReport) []string {
+	dir := ArtifactsDirOf(root, spec)
+	paths := []string{}
+	filepath.WalkDir(dir, func(path string, entry os.DirEntry, err error) error {
+		if err == nil && !entry.IsDir() {
+			abs, err := filepath.Abs(path)
+			if err == nil {
+				paths = append(paths, abs)
+			}
+		}
+		return nil
+	})
+	sort.Strings(paths)
+	return paths
+}
+
+// writeArtifacts replaces whatever an earlier run left for the spec, each stack gets a directory named after its project
+func writeArtifacts(dir string, snapshots map[string]map[string][]byte, spec types.SpecReport) error {
+	err := os.RemoveAll(dir)
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(dir, 0o755)
+	if err != nil {
+		return err
+	}
+	for project, files := range snapshots {
+		err = os.MkdirAll(filepath.Join(dir, project), 0o755)
+		if err != nil {
+			return err
+		}
+		for name, content := range files {
+			err = os.WriteFile(filepath.Join(dir, project, name), content, 0o644)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	exchanges := ExchangesOf(spec)
+	if len(exchanges) == 0 {
+		return nil
+	}
+	err = os.WriteFile(filepath.Join(dir, "exchange.http"), []byte(exchanges[len(exchanges)-1].String()), 0o644)
+	if err != nil {
+		return err
+	}
+	return BuildHAR([]types.SpecReport{spec}).Write(filepath.Join(dir, "exchanges.har"))
+}
+
+var _ = ReportAfterEach(func(report SpecReport) {
+	root := CurrentEnv().ArtifactsDir
+	snapshots := takeSnapshots(report.Failed() && root != "")
+	if !report.Failed() || root == "" {
+		return
+	}
+	dir := ArtifactsDirOf(root, report)
+	if len(snapshots) == 0 && len(ExchangesOf(report)) == 0 {
+		// Nothing to save, but the report must not link what an earlier run left behind
+		os.RemoveAll(dir)
+		return
+	}
+	err := writeArtifacts(dir, snapshots, report)
+	if err != nil {
+		log.Printf("Failed to save the artifacts of %q: %v", report.FullText(), err)
+		return
+	}
+	fmt.Fprintf(os.Stderr, "Saved the artifacts of %q to %s\n", report.FullText(), dir)
+})
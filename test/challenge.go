@@ -0,0 +1,96 @@
+package tests
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"strings"
+
+	openapi "github.com/DFMailbox/go-client"
+	"github.com/google/uuid"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+// A signed answer is only worth anything for the one challenge it answers, and only in the encoding the protocol defines
+var _ = Describe("Challenge freshness", Ordered, Label("federation"), func() {
+	var client *openapi.APIClient
+	var ctx context.Context
+	var target *Target
+	BeforeAll(func() {
+		t, err := SetupDefault()
+		target = t
+		Expect(err).ShouldNot(HaveOccurred())
+
+		ctx = target.Context()
+		client = target.Client()
+	})
+	introduce := func(peer *MockPeer) (*http.Response, error) {
+		return client.InstanceAPI.IntroduceInstance(ctx).IntroduceInstanceRequest(
+			*openapi.NewIntroduceInstanceRequest(peer.PublicKey(), peer.Address),
+		).Execute()
+	}
+
+	It("should reject an answer replayed from an earlier challenge", Requirement("FED-REPLAY-001"), func() {
+		// The genuine answer to the first challenge never reaches the instance, so the key is still unknown when it is replayed
+		peer := NewMockPeer(TestKey("replayed"), Replay(), Once(RespondStatus(http.StatusServiceUnavailable)))
+		resp, err := introduce(peer)
+		Expect(err).Should(HaveOccurred())
+		Expect(resp).Should(BeProblem(ProblemNonCompliance, 400).WithExtension("address", peer.Address))
+		ExpectUnknownInstance(client, ctx, peer.Key())
+
+		resp, err = introduce(peer)
+		Expect(err).Should(HaveOccurred())
+		challenges := peer.Challenges()
+		Expect(challenges).Should(HaveLen(2))
+		challenge := challenges[1]
+		Expect(resp).Should(BeProblem(ProblemChallengeFailed, 400).
+			WithExtension("challenge_bytes", base64.RawStdEncoding.EncodeToString(ChallengeBytes(peer.Address, challenge))))
+		ExpectUnknownInstance(client, ctx, peer.Key())
+	})
+	It("should never repeat a challenge", Requirement("FED-REPLAY-002"), func() {
+		const introductions = 25
+		seen := map[uuid.UUID]bool{}
+		for i := range introductions {
+			peer := NewMockPeer(TestKey(fmt.Sprintf("unique %d", i)))
+			resp, err := introduce(peer)
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(resp.StatusCode).Should(Equal(200))
+			challenge := peer.ExpectChallenge()
+			Expect(seen).ShouldNot(HaveKey(challenge), "the instance reused a challenge")
+			seen[challenge] = true
+		}
+	})
+	DescribeTable("should read the signature as standard base64",
+		func(name string, enc *base64.Encoding, accepted bool) {
+			// A signature without + or / reads the same in either alphabet, only one with them tells them apart
+			for attempt := range 10 {
+				peer := NewMockPeer(TestKey(fmt.Sprintf("%s %d", name, attempt)), EncodeSignature(enc))
+				resp, err := introduce(peer)
+				challenge := peer.ExpectChallenge()
+				answers := peer.Answers()
+				Expect(answers).Should(HaveLen(1))
+				Expect(answers[0].Challenge).Should(Equal(challenge))
+				if !accepted && !strings.ContainsAny(answers[0].Body.Signature, "-_") {
+					Expect(err).ShouldNot(HaveOccurred(), "the signature is standard base64 too")
+					Expect(resp.StatusCode).Should(Equal(200))
+					continue
+				}
+				if accepted {
+					Expect(err).ShouldNot(HaveOccurred())
+					Expect(resp.StatusCode).Should(Equal(200))
+				} else {
+					Expect(err).Should(HaveOccurred())
+					Expect(resp).Should(BeProblem(ProblemNonCompliance, 400).WithExtension("address", peer.Address))
+					ExpectUnknownInstance(client, ctx, peer.Key())
+				}
+				return
+			}
+			Fail("no signature in 10 introductions used a character only the url-safe alphabet has")
+		},
+		Entry("padded", Requirement("FED-ENCODING-001"), "padded", base64.StdEncoding, true),
+		Entry("url-safe", Requirement("FED-ENCODING-002"), "url-safe", base64.RawURLEncoding, false),
+		Entry("url-safe and padded", Requirement("FED-ENCODING-003"), "url-safe and padded", base64.URLEncoding, false),
+	)
+})
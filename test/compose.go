@@ -0,0 +1,162 @@
+package tests
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/compose-spec/compose-go/v2/cli"
+	"github.com/compose-spec/compose-go/v2/types"
+	"github.com/docker/go-connections/nat"
+)
+
+// DefaultService is the compose service the suite talks to unless DFMC_SERVICE says otherwise
+const DefaultService = "dfmailbox"
+
+// loadProject parses a compose file with env interpolated, the way the stack started from it will see it.
+// Like the stack, it never reads .env or the environment of the suite.
+func loadProject(ctx context.Context, file_path string, env map[string]string) (*types.Project, error) {
+	vars := make([]string, 0, len(env))
+	for k, v := range env {
+		vars = append(vars, k+"="+v)
+	}
+	options, err := cli.NewProjectOptions(
+		[]string{file_path},
+		cli.WithName("dfmc"),
+		cli.WithWorkingDirectory(filepath.Dir(file_path)),
+		cli.WithEnv(vars),
+	)
+	if err != nil {
+		return nil, err
+	}
+	return options.LoadProject(ctx)
+}
+
+// instancePort is the container port of the instance, the configured one or else the first the service publishes
+func instancePort(project *types.Project, env Environment) (nat.Port, error) {
+	service, ok := project.Services[env.Service]
+	if !ok {
+		return "", fmt.Errorf("%s has no %q service, set DFMC_SERVICE to the service of your instance, one of %s",
+			project.ComposeFiles[0], env.Service, strings.Join(slices.Sorted(slices.Values(project.ServiceNames())), ", "))
+	}
+	if env.Port != "" {
+		port, err := nat.NewPort(nat.SplitProtoPort(env.Port))
+		if err != nil {
+			return "", fmt.Errorf("DFMC_PORT %q is not a port like 8080 or 8080/tcp", env.Port)
+		}
+		if !slices.Contains(publishedPorts(service), port) {
+			return "", fmt.Errorf("the %q service doesn't publish %s, add it to its ports", env.Service, port)
+		}
+		return port, nil
+	}
+	published := publishedPorts(service)
+	if len(published) == 0 {
+		return "", fmt.Errorf("the %q service publishes no port, add the port the instance listens on to its ports", env.Service)
+	}
+	return published[0], nil
+}
+
+// publishedPorts lists the container ports of a service in the order the compose file has them
+func publishedPorts(service types.ServiceConfig) []nat.Port {
+	ports := []nat.Port{}
+	for _, config := range service.Ports {
+		proto := config.Protocol
+		if proto == "" {
+			proto = "tcp"
+		}
+		ports = append(ports, nat.Port(fmt.Sprintf("%d/%s", config.Target, proto)))
+	}
+	return ports
+}
+
+// What ValidateCompose passes for the variables the suite provides, to find where the compose file uses them
+const (
+	probeAddress = "dfmc-probe-address.invalid"
+	probeKey     = "DFMC0PROBE0KEY"
+	probeMojang  = "http://dfmc-probe-mojang.invalid"
+)
+
+// ValidateCompose checks a compose file against every rule the README sets before a stack is started from it,
+// every rule that is broken gets an error saying how to fix it
+func ValidateCompose(ctx context.Context, file_path string, env Environment) error {
+	project, err := loadProject(ctx, file_path, map[string]string{
+		"DFMC_ADDRESS":      probeAddress,
+		"DFMC_PRIVATE_KEY":  probeKey,
+		"DFMC_HOST_GATEWAY": env.HostGateway,
+		"DFMC_MOJANG_API":   probeMojang,
+	})
+	if err != nil {
+		return fmt.Errorf("%s is not a valid compose file: %v", file_path, err)
+	}
+	service, ok := project.Services[env.Service]
+	if !ok {
+		_, err := instancePort(project, env)
+		return err
+	}
+	problems := []error{}
+	if _, err := instancePort(project, env); err != nil {
+		problems = append(problems, err)
+	}
+	for _, port := range service.Ports {
+		if port.Published != "" {
+			problems = append(problems, fmt.Errorf("the %q service publishes %d on host port %s, use `- %d` under its ports so docker picks a free one",
+				env.Service, port.Target, port.Published, port.Target))
+		}
+	}
+	for _, probe := range []struct{ variable, value, example string }{
+		{"DFMC_ADDRESS", probeAddress, "HOST: ${DFMC_ADDRESS}"},
+		{"DFMC_PRIVATE_KEY", probeKey, "SECRET_KEY: ${DFMC_PRIVATE_KEY}"},
+	} {
+		if !usesValue(service, probe.value) {
+			problems = append(problems, fmt.Errorf("the %q service never gets %s, pass it in its environment, e.g. `%s`",
+				env.Service, probe.variable, probe.example))
+		}
+	}
+	for _, host := range []string{"host.docker.internal", "alt-host.docker.internal"} {
+		if _, ok := service.ExtraHosts[host]; !ok {
+			problems = append(problems, fmt.Errorf("the %q service can't resolve %s, add `- \"%s:${DFMC_HOST_GATEWAY:-host-gateway}\"` to its extra_hosts",
+				env.Service, host, host))
+		}
+	}
+	// Stacks started side by side only stay apart when compose names everything after the project
+	for _, name := range project.ServiceNames() {
+		if container := project.Services[name].ContainerName; container != "" {
+			problems = append(problems, fmt.Errorf("the %q service sets container_name %q, remove it so parallel stacks don't clash", name, container))
+		}
+	}
+	for _, key := range slices.Sorted(maps.Keys(project.Networks)) {
+		network := project.Networks[key]
+		if bool(network.External) || network.Name != project.Name+"_"+key {
+			problems = append(problems, fmt.Errorf("the %q network sets a name or is external, remove both so parallel stacks don't share it", key))
+		}
+	}
+	for _, key := range slices.Sorted(maps.Keys(project.Volumes)) {
+		volume := project.Volumes[key]
+		if bool(volume.External) || volume.Name != project.Name+"_"+key {
+			problems = append(problems, fmt.Errorf("the %q volume sets a name or is external, remove both so parallel stacks don't share it", key))
+		}
+	}
+	if len(problems) == 0 {
+		return nil
+	}
+	return fmt.Errorf("%s doesn't follow the README:\n%w", file_path, errors.Join(problems...))
+}
+
+// usesValue reports whether value makes it into the environment, command or entrypoint of the service
+func usesValue(service types.ServiceConfig, value string) bool {
+	for _, v := range service.Environment {
+		if v != nil && strings.Contains(*v, value) {
+			return true
+		}
+	}
+	for _, arg := range slices.Concat(service.Command, service.Entrypoint) {
+		if strings.Contains(arg, value) {
+			return true
+		}
+	}
+	return false
+}
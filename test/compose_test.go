@@ -0,0 +1,54 @@
+package tests
+
+import (
+	"context"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Validating compose files", Label("unit"), func() {
+	fixture := func(name string) string {
+		return filepath.Join("testdata", "compose", name)
+	}
+	port := func(port string) Environment {
+		return Environment{Service: DefaultService, Port: port}
+	}
+
+	DescribeTable("should accept a file that follows the README",
+		func(file string, env Environment) {
+			Expect(ValidateCompose(context.Background(), fixture(file), env)).Should(Succeed())
+		},
+		Entry("with the first port", "valid.yml", port("")),
+		Entry("with the port DFMC_PORT picks", "two-ports.yml", port("9090")),
+		Entry("with a protocol in DFMC_PORT", "two-ports.yml", port("9090/tcp")),
+		Entry("with a network and volume named after the project", "project-network-and-volume.yml", port("")),
+	)
+	DescribeTable("should say how to fix a file that doesn't",
+		func(file string, env Environment, problem string) {
+			err := ValidateCompose(context.Background(), fixture(file), env)
+			Expect(err).Should(MatchError(ContainSubstring(problem)))
+		},
+		Entry("a fixed host port", "fixed-port.yml", port(""),
+			"the \"dfmailbox\" service publishes 8080 on host port 8080, use `- 8080` under its ports"),
+		Entry("missing extra_hosts", "no-extra-hosts.yml", port(""),
+			"the \"dfmailbox\" service can't resolve host.docker.internal"),
+		Entry("missing the second extra host", "no-extra-hosts.yml", port(""),
+			"the \"dfmailbox\" service can't resolve alt-host.docker.internal"),
+		Entry("an unused private key", "no-private-key.yml", port(""),
+			"the \"dfmailbox\" service never gets DFMC_PRIVATE_KEY"),
+		Entry("container_name", "container-name.yml", port(""),
+			"the \"dfmailbox\" service sets container_name \"dfmailbox\""),
+		Entry("a named network", "named-network.yml", port(""),
+			"the \"mail\" network sets a name or is external"),
+		Entry("an external volume", "external-volume.yml", port(""),
+			"the \"data\" volume sets a name or is external"),
+		Entry("DFMC_PORT not published", "valid.yml", port("9090"),
+			"the \"dfmailbox\" service doesn't publish 9090/tcp"),
+		Entry("DFMC_PORT not a port", "valid.yml", port("http"),
+			"DFMC_PORT \"http\" is not a port"),
+		Entry("an unknown service", "valid.yml", Environment{Service: "api"},
+			"has no \"api\" service"),
+	)
+})
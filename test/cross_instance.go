@@ -0,0 +1,104 @@
+package tests
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	openapi "github.com/DFMailbox/go-client"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+// instanceUnderTest is one of several instances a spec starts, along with the plot it registers on it
+type instanceUnderTest struct {
+	*Target
+	// The EncodedPublicKey the instance was started with
+	Key   string
+	Plot  int32
+	Owner string
+	Uuid  string
+}
+
+// Two copies of the implementation under test have to agree with each other, not just with our mock.
+// Mail between them is a proposal, the client has no federation mailbox endpoint yet.
+var _ = Describe("Mail between two instances", Ordered, Label("federation", "mailbox", "cross-instance"), func() {
+	a := &instanceUnderTest{Plot: 5001, Owner: "Notch", Uuid: "069a79f4-44e9-4726-a5be-fca90e38aaf5"}
+	b := &instanceUnderTest{Plot: 5002, Owner: "jeb_", Uuid: "853c80ef-3c37-49fd-aa49-938b674adae6"}
+	var ctx context.Context
+	BeforeAll(func() {
+		if CurrentEnv().TargetURL != "" {
+			Skip("DFMC_TARGET_URL names a single instance, these specs start two with compose")
+		}
+		// The interop runner points B at another implementation
+		env := CurrentEnv()
+		aKey, bKey := TestKey("A"), TestKey("B")
+		a.Key, b.Key = EncodedPublicKey(aKey), EncodedPublicKey(bKey)
+		var err error
+		a.Target, err = SetupReachable(env, aKey)
+		Expect(err).ShouldNot(HaveOccurred())
+		b.Target, err = SetupReachable(env.Peer(), bKey)
+		Expect(err).ShouldNot(HaveOccurred())
+		ctx = a.Context()
+	})
+	introduce := func(from *instanceUnderTest, to *instanceUnderTest) {
+		GinkgoHelper()
+		client := from.Client()
+		resp, err := client.InstanceAPI.IntroduceInstance(ctx).IntroduceInstanceRequest(
+			*openapi.NewIntroduceInstanceRequest(to.Key, to.Address),
+		).Execute()
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(resp.StatusCode).Should(Equal(200))
+
+		oai, _, err := client.InstanceAPI.LookupInstanceAddress(ctx).PublicKey(to.Key).Execute()
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(oai.LookupInstanceAddress200ResponseOneOf.Instance.Address.Get()).Should(HaveValue(Equal(to.Address)))
+	}
+	// send mails the plot on from to the plot on to and checks that it arrived as sent
+	send := func(from *instanceUnderTest, to *instanceUnderTest) {
+		GinkgoHelper()
+		resp, err := from.Do(AddPlotAuth(ctx, from.Owner, from.Plot), http.MethodPost, fmt.Sprintf("/v0/mailbox/%d", to.Plot), map[string]any{
+			"data":       map[string]any{"from": from.Plot, "to": to.Plot},
+			"public_key": to.Key,
+		})
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(resp.StatusCode).Should(Equal(201))
+		id := DecodeJSON[struct {
+			Id int64 `json:"id"`
+		}](resp).Id
+
+		page := ReadMailbox(to.Target, AddPlotAuth(ctx, to.Owner, to.Plot), to.Plot, "")
+		Expect(page.MailboxMsgId).Should(Equal(id))
+		Expect(page.Messages).Should(ContainElement(And(
+			HaveField("Id", id),
+			HaveField("From", MailboxSender{PlotId: from.Plot, PublicKey: &from.Key}),
+			HaveField("Data", MatchJSON(fmt.Sprintf(`{"from": %d, "to": %d}`, from.Plot, to.Plot))),
+		)))
+	}
+
+	It("should identify B from A", Requirement("XFED-IDENTIFY-001"), func() {
+		introduce(a, b)
+	})
+	It("should identify A from B", Requirement("XFED-IDENTIFY-002"), func() {
+		introduce(b, a)
+	})
+	It("should register a plot on each instance", Requirement("XFED-PLOT-001"), func() {
+		for _, side := range []*instanceUnderTest{a, b} {
+			RegisterCheckPlot(side.Client(), side.Context(), side.Owner, side.Uuid, side.Plot)
+		}
+	})
+	It("should deliver mail from A to B", Requirement("XMAIL-SEND-001"), Label(ProposalLabel), func() {
+		send(a, b)
+	})
+	It("should deliver mail from B to A", Requirement("XMAIL-SEND-002"), Label(ProposalLabel), func() {
+		send(b, a)
+	})
+	It("should relay the problem for a plot the other instance doesn't have", Requirement("XMAIL-SEND-003"), Label(ProposalLabel), func() {
+		resp, err := a.Do(AddPlotAuth(ctx, a.Owner, a.Plot), http.MethodPost, "/v0/mailbox/5999", map[string]any{
+			"data":       "hello",
+			"public_key": b.Key,
+		})
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(resp).Should(BeProblem(ProblemUnknownPlot, 404).WithExtension("plot_id", 5999))
+	})
+})
@@ -0,0 +1,6 @@
+// Package tests is the DFMailbox compliance suite.
+//
+// The specs live in regular .go files rather than _test.go ones, so the dfmc binary can import and run them.
+// Put new specs in a regular file too, a spec in a _test.go file only runs under go test and ginkgo.
+// The _test.go files hold the suite's own unit specs, labelled unit, which never talk to an instance.
+package tests
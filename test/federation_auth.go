@@ -0,0 +1,190 @@
+package tests
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	openapi "github.com/DFMailbox/go-client"
+	"github.com/google/uuid"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+// SignedRequest is a request one instance sends another on the federation API.
+// Change any field and call Sign again to get a request that is wrong in just that way.
+type SignedRequest struct {
+	Method    string
+	Path      string
+	Body      []byte
+	PublicKey string
+	Timestamp int64
+	Nonce     string
+	Signature string
+}
+
+// RequestBytes is what an instance signs to authenticate a federation request
+func RequestBytes(method string, path string, timestamp int64, nonce string, body []byte) []byte {
+	signed := fmt.Appendf(nil, "%s %s\n%d\n%s\n", method, path, timestamp, nonce)
+	return append(signed, body...)
+}
+
+// NewRequest builds a request from the peer to path with a fresh timestamp and nonce, signed with its key
+func (p *MockPeer) NewRequest(method string, path string, body any) *SignedRequest {
+	GinkgoHelper()
+	encoded, err := json.Marshal(body)
+	Expect(err).ShouldNot(HaveOccurred())
+	req := &SignedRequest{
+		Method:    method,
+		Path:      path,
+		Body:      encoded,
+		PublicKey: p.PublicKey(),
+		Timestamp: time.Now().Unix(),
+		Nonce:     uuid.NewString(),
+	}
+	req.Sign(p.Key())
+	return req
+}
+
+// Bytes is what the request is signed over
+func (r *SignedRequest) Bytes() []byte {
+	return RequestBytes(r.Method, r.Path, r.Timestamp, r.Nonce, r.Body)
+}
+
+// Sign replaces the signature with one made by key, which only verifies if key is the one in PublicKey
+func (r *SignedRequest) Sign(key ed25519.PrivateKey) {
+	r.Signature = base64.RawStdEncoding.EncodeToString(ed25519.Sign(key, r.Bytes()))
+}
+
+// Send delivers the request to the target as is, sending it twice replays it
+func (r *SignedRequest) Send(ctx context.Context, target *Target) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, r.Method, target.URL+r.Path, bytes.NewReader(r.Body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Dfm-Public-Key", r.PublicKey)
+	req.Header.Set("Dfm-Timestamp", strconv.FormatInt(r.Timestamp, 10))
+	req.Header.Set("Dfm-Nonce", r.Nonce)
+	req.Header.Set("Dfm-Signature", r.Signature)
+	return target.DoRequest(req)
+}
+
+// The instance under test has to check who is calling its federation API, not just answer challenges.
+// A proposal, the published API has no signed requests yet and the Dfm-* headers are the mock's.
+var _ = Describe("Signed federation requests", Ordered, Label("federation", "mailbox", ProposalLabel), func() {
+	var client *openapi.APIClient
+	var ctx context.Context
+	var target *Target
+	var peer *MockPeer
+	// forger signs for the peer, stranger is never introduced
+	var forger, stranger ed25519.PrivateKey
+	const inbox = 6001
+	BeforeAll(func() {
+		t, err := SetupDefault()
+		target = t
+		Expect(err).ShouldNot(HaveOccurred())
+
+		ctx = target.Context()
+		client = target.Client()
+		RegisterCheckPlot(client, ctx, "Notch", "069a79f4-44e9-4726-a5be-fca90e38aaf5", inbox)
+		key := TestKey("sender")
+		forger = TestKey("forger")
+		stranger = TestKey("stranger")
+		peer = NewMockPeer(key)
+		_, err = client.InstanceAPI.IntroduceInstance(ctx).IntroduceInstanceRequest(
+			*openapi.NewIntroduceInstanceRequest(peer.PublicKey(), peer.Address),
+		).Execute()
+		Expect(err).ShouldNot(HaveOccurred())
+	})
+	// deliver is a message from plot 42 on sender to the inbox
+	deliver := func(sender *MockPeer, n int) *SignedRequest {
+		GinkgoHelper()
+		return sender.NewRequest(http.MethodPost, fmt.Sprintf("/v0/federation/mailbox/%d", inbox), map[string]any{
+			"from": map[string]any{"plot_id": 42, "public_key": sender.PublicKey()},
+			"data": map[string]any{"n": n},
+		})
+	}
+	newest := func() int64 {
+		GinkgoHelper()
+		return ReadMailbox(target, AddPlotAuth(ctx, "Notch", inbox), inbox, "").MailboxMsgId
+	}
+
+	It("should accept a request signed by an identified instance", Requirement("FED-AUTH-001"), func() {
+		resp, err := deliver(peer, 1).Send(ctx, target)
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(resp.StatusCode).Should(Equal(201))
+		id := DecodeJSON[struct {
+			Id int64 `json:"id"`
+		}](resp).Id
+
+		page := ReadMailbox(target, AddPlotAuth(ctx, "Notch", inbox), inbox, "")
+		Expect(page.Messages).Should(ContainElement(And(
+			HaveField("Id", id),
+			HaveField("From.PlotId", int32(42)),
+			HaveField("From.PublicKey", HaveValue(Equal(peer.PublicKey()))),
+			HaveField("Data", MatchJSON(`{"n": 1}`)),
+		)))
+	})
+	It("should reject an unsigned request", Requirement("FED-AUTH-002"), func() {
+		before := newest()
+		resp, err := target.Do(ctx, http.MethodPost, fmt.Sprintf("/v0/federation/mailbox/%d", inbox), map[string]any{
+			"from": map[string]any{"plot_id": 42, "public_key": peer.PublicKey()},
+			"data": "unsigned",
+		})
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(resp).Should(BeProblem(ProblemUnauthorized, 401))
+		Expect(newest()).Should(Equal(before))
+	})
+	It("should reject a request signed with another key", Requirement("FED-AUTH-003"), func() {
+		before := newest()
+		req := deliver(peer, 3)
+		req.Sign(forger)
+		resp, err := req.Send(ctx, target)
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(resp).Should(BeProblem(ProblemInvalidSignature, 401).
+			WithExtension("signed_bytes", base64.RawStdEncoding.EncodeToString(req.Bytes())))
+		Expect(newest()).Should(Equal(before))
+	})
+	DescribeTable("should reject a request with a timestamp outside the window",
+		func(skew time.Duration) {
+			before := newest()
+			req := deliver(peer, 4)
+			req.Timestamp += int64(skew.Seconds())
+			req.Sign(peer.Key())
+			resp, err := req.Send(ctx, target)
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(resp).Should(BeProblem(ProblemExpiredRequest, 401).WithExtension("timestamp", req.Timestamp))
+			Expect(newest()).Should(Equal(before))
+		},
+		Entry("in the past", Requirement("FED-AUTH-004"), -time.Hour),
+		Entry("in the future", Requirement("FED-AUTH-005"), time.Hour),
+	)
+	It("should reject a request from an instance that was never introduced", Requirement("FED-AUTH-006"), func() {
+		before := newest()
+		unknown := NewMockPeer(stranger)
+		resp, err := deliver(unknown, 6).Send(ctx, target)
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(resp).Should(BeProblem(ProblemUnknownInstance, 409))
+		Expect(newest()).Should(Equal(before))
+		Expect(unknown.Len()).Should(BeZero(), "the instance should not challenge a peer that calls it")
+	})
+	It("should reject a replayed request", Requirement("FED-AUTH-007"), func() {
+		req := deliver(peer, 7)
+		resp, err := req.Send(ctx, target)
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(resp.StatusCode).Should(Equal(201))
+		before := newest()
+
+		resp, err = req.Send(ctx, target)
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(resp).Should(BeProblem(ProblemReplayedNonce, 409).WithExtension("nonce", req.Nonce))
+		Expect(newest()).Should(Equal(before))
+	})
+})
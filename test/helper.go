@@ -1,128 +1,417 @@
 package tests
 
 import (
+	"bytes"
 	"context"
 	"crypto/ed25519"
 	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"log"
 	"net"
 	"net/http"
-	"net/http/httptest"
 	"os"
 	"regexp"
+	"strconv"
+	"strings"
+	"sync"
 	"sync/atomic"
 
 	openapi "github.com/DFMailbox/go-client"
-	"github.com/docker/go-connections/nat"
-	"github.com/google/uuid"
+	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
 	"github.com/testcontainers/testcontainers-go/modules/compose"
 	"github.com/testcontainers/testcontainers-go/wait"
 )
 
-// Pretty please don't mutate this
-var keys []string = []string{
-	"TESTING0KEYTESTING0KEYTESTING0KEYTESTING000=",
-	"TESTING0KEYTESTING0KEYTESTING0KEYTESTING001=",
-	"TESTING0KEYTESTING0KEYTESTING0KEYTESTING002=",
-	"TESTING0KEYTESTING0KEYTESTING0KEYTESTING003=",
-	"TESTING0KEYTESTING0KEYTESTING0KEYTESTING004=",
-	"TESTING0KEYTESTING0KEYTESTING0KEYTESTING005=",
-	"TESTING0KEYTESTING0KEYTESTING0KEYTESTING006=",
-}
+// SuiteDescription is the name every runner reports the suite under
+const SuiteDescription = "DFMailbox compliance test suite"
+
+// defaultPrivateKey is the seed of the instance every single-instance spec runs against, DFMC_TARGET_URL documents it
+const defaultPrivateKey = "TESTING0KEYTESTING0KEYTESTING0KEYTESTING000="
 
-var extKeys []ed25519.PrivateKey
 var uuidRegex = regexp.MustCompile(`^[a-fA-F0-9]{8}-[a-fA-F0-9]{4}-[1-5][a-fA-F0-9]{3}-[89abAB][a-fA-F0-9]{3}-[a-fA-F0-9]{12}$`)
 
-func init() {
-	sKeys := []string{
-		"TESTING0KEYTESTINGju9ahiajieng6ohRiu1Ie1zae=",
-		"TESTING0KEYTESTINGeR4haaxohghai2Eeg5een1AhV=",
-		"TESTING0KEYTESTINGeu6ti3Ohpei7Eej7feid3te7q=",
-		"TESTING0KEYTESTINGShaeni7aeLee3ahjeeghaidon=",
-		"TESTING0KEYTESTINGeiz5iu8bangeXahcie8Idoobe=",
-		"TESTING0KEYTESTINGJlei9ohshoJ5Ey9Busiu4OR6a=",
-		"TESTING0KEYTESTINGzohran2aevohyaime5toh6AhT=",
+func ReadEnv() Environment {
+	path, set := os.LookupEnv("DFMC_COMPOSE_FILE")
+	if set == false {
+		path = "../../compliance-docker-compose.yml"
+	}
+	service := os.Getenv("DFMC_SERVICE")
+	if service == "" {
+		service = DefaultService
+	}
+	shared, _ := strconv.ParseBool(os.Getenv("DFMC_SHARED_STACK"))
+	artifacts, set := os.LookupEnv("DFMC_ARTIFACTS_DIR")
+	if !set {
+		artifacts = DefaultArtifactsDir
+	}
+	seed, set := os.LookupEnv("DFMC_KEY_SEED")
+	if !set {
+		seed = DefaultKeySeed
+	}
+	return Environment{
+		HostGateway:     os.Getenv("DFMC_HOST_GATEWAY"),
+		ComposePath:     path,
+		PeerComposePath: os.Getenv("DFMC_PEER_COMPOSE_FILE"),
+		TargetURL:       strings.TrimSuffix(os.Getenv("DFMC_TARGET_URL"), "/"),
+		JSONReport:      os.Getenv("DFMC_REPORT_JSON"),
+		JUnitReport:     os.Getenv("DFMC_REPORT_JUNIT"),
+		KeySeed:         seed,
+		SharedStack:     shared,
+		ArtifactsDir:    artifacts,
+		HARPath:         os.Getenv("DFMC_HAR"),
+		Service:         service,
+		Port:            os.Getenv("DFMC_PORT"),
+		PeerService:     os.Getenv("DFMC_PEER_SERVICE"),
+		PeerPort:        os.Getenv("DFMC_PEER_PORT"),
+	}
+}
+
+type Environment struct {
+	ComposePath string
+	// Compose file of the second instance in specs that start two, ComposePath when empty
+	PeerComposePath string
+	HostGateway     string
+	// When set, specs run against this already running instance and compose is never touched
+	TargetURL string
+	// Where to write the compliance report, nothing is written when empty
+	JSONReport  string
+	JUnitReport string
+	// What TestKey derives keys from, the same seed hands every spec the same keys
+	KeySeed string
+	// Start one stack per ginkgo process and reset it between Describe blocks, when the compose file declares how
+	SharedStack bool
+	// Where failed specs save the logs and state of their stacks, nothing is saved when empty
+	ArtifactsDir string
+	// Where to write every exchange with an instance as an HTTP Archive, nothing is written when empty
+	HARPath string
+	// The compose service of the instance, and its container port when it isn't the first the service publishes
+	Service string
+	Port    string
+	// The same for the peer compose file, Service and Port when empty
+	PeerService string
+	PeerPort    string
+}
+
+// PeerCompose is the compose file the second instance of a cross-instance spec is started from
+func (e Environment) PeerCompose() string {
+	if e.PeerComposePath != "" {
+		return e.PeerComposePath
+	}
+	return e.ComposePath
+}
+
+// Peer is the environment the second instance of a cross-instance spec is started in,
+// with the peer compose file, service and port in place of the first instance's
+func (e Environment) Peer() Environment {
+	peer := e
+	peer.ComposePath = e.PeerCompose()
+	if e.PeerService != "" {
+		peer.Service = e.PeerService
+	}
+	if e.PeerPort != "" {
+		peer.Port = e.PeerPort
+	}
+	return peer
+}
+
+var configuredEnv *Environment
+
+// Configure replaces the environment variables as the source of the Environment, used when running outside of ginkgo
+func Configure(env Environment) {
+	configuredEnv = &env
+}
+
+func CurrentEnv() Environment {
+	if configuredEnv != nil {
+		return *configuredEnv
 	}
-	keyList := make([]ed25519.PrivateKey, len(sKeys))
+	return ReadEnv()
+}
+
+// Target is the DFMailbox instance under test. Stack is nil when the instance was started by the user.
+type Target struct {
+	Stack *compose.DockerCompose
+	// The compose project of Stack
+	Project string
+	URL     string
+	// The address the instance signs challenges with, empty when the user started it
+	Address string
+
+	// The compose file, service and port the stack is started with
+	env      Environment
+	forward  net.Listener
+	teardown sync.Once
+}
+
+// Client creates an API client that talks to the target
+func (t *Target) Client() *openapi.APIClient {
+	config := openapi.NewConfiguration()
+	config.Servers = openapi.ServerConfigurations{{URL: t.URL}}
+	config.HTTPClient = targetClient
+	return openapi.NewAPIClient(config)
+}
+
+func (t *Target) Context() context.Context {
+	return context.WithValue(context.Background(), openapi.ContextServerIndex, 0)
+}
 
-	for i, str := range sKeys {
-		key, err := base64.StdEncoding.DecodeString(str)
+// Do sends a request for an endpoint the generated client has no method for, with the plot auth from ctx if any.
+// Unlike the client it never turns a status into an error, and the body of the response stays readable.
+func (t *Target) Do(ctx context.Context, method string, path string, body any) (*http.Response, error) {
+	var reader io.Reader
+	if body != nil {
+		encoded, err := json.Marshal(body)
 		if err != nil {
-			panic("key is invalid base64")
+			return nil, err
 		}
-		if len(key) != ed25519.SeedSize {
-			panic(fmt.Sprintf("key is invalid length %d", len(key)))
+		reader = bytes.NewReader(encoded)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, t.URL+path, reader)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if auth, ok := ctx.Value(openapi.ContextAPIKeys).(map[string]openapi.APIKey); ok {
+		if plot, ok := auth["Plot"]; ok {
+			req.Header.Set("User-Agent", plot.Key)
 		}
-		keyList[i] = ed25519.NewKeyFromSeed(key)
 	}
-	extKeys = keyList
+	return t.DoRequest(req)
 }
 
-func ReadEnv() Environment {
-	path, set := os.LookupEnv("DFMC_COMPOSE_FILE")
-	if set == false {
-		path = "../../compliance-docker-compose.yml"
+// DoRequest sends a request built by hand, the body of the response stays readable
+func (t *Target) DoRequest(req *http.Request) (*http.Response, error) {
+	resp, err := targetClient.Do(req)
+	if err != nil {
+		return nil, err
 	}
-	return Environment{
-		HostGateway: os.Getenv("DFMC_HOST_GATEWAY"),
-		ComposePath: path,
+	raw, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	resp.Body = io.NopCloser(bytes.NewReader(raw))
+	return resp, err
+}
+
+// defaultAddress is what the instance SetupDefault starts signs challenges with
+const defaultAddress = "dfm.example.com"
+
+func SetupDefault() (*Target, error) {
+	env := CurrentEnv()
+	if env.TargetURL != "" {
+		return &Target{URL: env.TargetURL}, nil
+	}
+	if sharedTarget != nil {
+		err := sharedTarget.reset(sharedHooks)
+		if err != nil {
+			return nil, err
+		}
+		return sharedTarget, nil
+	}
+	vars, err := defaultStackEnv(env)
+	if err != nil {
+		return nil, err
+	}
+	target, err := Setup(env, vars)
+	if err != nil {
+		return nil, err
 	}
+	target.Address = defaultAddress
+	return target, nil
 }
 
-type Environment struct {
-	ComposePath string
-	HostGateway string
+// defaultStackEnv is the compose environment of the instance every single-instance spec runs against
+func defaultStackEnv(env Environment) (map[string]string, error) {
+	return stackEnv(env, defaultAddress, defaultPrivateKey)
 }
 
-func SetupDefault() (*compose.DockerCompose, *nat.Port, error) {
-	env := ReadEnv()
-	return Setup(env.ComposePath, map[string]string{
-		"DFMC_ADDRESS":      "dfm.example.com",
-		"DFMC_PRIVATE_KEY":  keys[0],
+// stackEnv is the compose environment of an instance at address with the private key seed
+func stackEnv(env Environment, address string, key string) (map[string]string, error) {
+	mojang, err := MojangAPI()
+	if err != nil {
+		return nil, err
+	}
+	return map[string]string{
+		"DFMC_ADDRESS":      address,
+		"DFMC_PRIVATE_KEY":  key,
 		"DFMC_HOST_GATEWAY": env.HostGateway,
-	})
+		"DFMC_MOJANG_API":   mojang,
+	}, nil
 }
 
-func Setup(file_path string, env map[string]string) (*compose.DockerCompose, *nat.Port, error) {
-	ctx := context.Background()
+// SetupReachable starts a stack that other containers can reach at its address, for specs with several real instances.
+// The address is a port on host.docker.internal that the suite forwards to the container, since the port
+// compose maps is only known once the instance is already running with its address.
+func SetupReachable(env Environment, key ed25519.PrivateKey) (*Target, error) {
+	listener, err := net.Listen("tcp", "0.0.0.0:0")
+	if err != nil {
+		return nil, fmt.Errorf("Failed to listen for the instance %v", err)
+	}
+	address := fmt.Sprintf("host.docker.internal:%d", listener.Addr().(*net.TCPAddr).Port)
+	vars, err := stackEnv(env, address, base64.StdEncoding.EncodeToString(key.Seed()))
+	if err != nil {
+		listener.Close()
+		return nil, err
+	}
+	target, err := Setup(env, vars)
+	if err != nil {
+		listener.Close()
+		return nil, err
+	}
+	target.Address = address
+	target.forward = listener
+	go forward(listener, strings.TrimPrefix(target.URL, "http://"))
+	return target, nil
+}
+
+// forward pipes every connection accepted by listener to upstream until the listener is closed
+func forward(listener net.Listener, upstream string) {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			defer conn.Close()
+			up, err := net.Dial("tcp", upstream)
+			if err != nil {
+				log.Printf("Failed to forward to %s: %v", upstream, err)
+				return
+			}
+			defer up.Close()
+			done := make(chan struct{}, 2)
+			go func() {
+				io.Copy(up, conn)
+				done <- struct{}{}
+			}()
+			go func() {
+				io.Copy(conn, up)
+				done <- struct{}{}
+			}()
+			<-done
+		}()
+	}
+}
+
+// Setup starts the stack in the compose file of env under a compose project of its own and tears it down once the
+// current container is done, even when the node calling Setup fails halfway.
+func Setup(env Environment, vars map[string]string) (*Target, error) {
+	target, err := newStack(stackIdentifier(), env)
+	if err != nil {
+		return nil, err
+	}
+	err = target.up(vars)
+	if err != nil {
+		return nil, err
+	}
+	return target, nil
+}
+
+// newStack checks the compose file and creates the project without starting it, its teardown is registered before anything can fail
+func newStack(project string, env Environment) (*Target, error) {
+	err := ValidateCompose(context.Background(), env.ComposePath, env)
+	if err != nil {
+		return nil, err
+	}
 	stack, err := compose.NewDockerComposeWith(
-		// compose.StackIdentifier("dfm_compliance"),
-		compose.WithStackFiles(file_path),
+		compose.StackIdentifier(project),
+		compose.WithStackFiles(env.ComposePath),
 	)
 	if err != nil {
-		return nil, nil, errors.New(fmt.Sprintf("Failed to create stack %v", err))
+		return nil, errors.New(fmt.Sprintf("Failed to create stack %v", err))
 	}
-	err = stack.WithEnv(env).
-		WaitForService("dfmailbox", wait.ForExposedPort()).
+	target := &Target{Stack: stack, Project: project, env: env}
+	// Registered before Up, a stack that only partly started still has containers to remove
+	DeferCleanup(Teardown, target)
+	trackStack(target)
+	return target, nil
+}
+
+// up starts the stack and points the target at the port of the instance's service
+func (t *Target) up(env map[string]string) error {
+	ctx := context.Background()
+	config := t.env
+	project, err := loadProject(ctx, config.ComposePath, env)
+	if err != nil {
+		return errors.New(fmt.Sprintf("Failed to load %s %v", config.ComposePath, err))
+	}
+	port, err := instancePort(project, config)
+	if err != nil {
+		return err
+	}
+	GinkgoWriter.Printf("Starting compose project %s\n", t.Project)
+	err = t.Stack.WithEnv(env).
+		WaitForService(config.Service, wait.ForListeningPort(port)).
 		Up(ctx, compose.Wait(true))
 	if err != nil {
-		return nil, nil, errors.New(fmt.Sprintf("Failed to start compose stack %v", err))
+		return errors.New(fmt.Sprintf("Failed to start compose stack %s %v", t.Project, err))
 	}
-	container, err := stack.ServiceContainer(ctx, "dfmailbox")
+	container, err := t.Stack.ServiceContainer(ctx, config.Service)
 	if err != nil {
-		return nil, nil, errors.New(fmt.Sprintf("Failed to find container %v", err))
+		return errors.New(fmt.Sprintf("Failed to find container %v", err))
 	}
-	port, err := container.MappedPort(ctx, "8080/tcp")
+	mapped, err := container.MappedPort(ctx, port)
 	if err != nil {
-		return nil, nil, errors.New(fmt.Sprintf("Failed to find container endpoint %v", err))
+		return errors.New(fmt.Sprintf("Failed to find container endpoint %v", err))
 	}
-	return stack, &port, nil
+	t.URL = fmt.Sprintf("http://localhost:%s", mapped.Port())
+	return nil
 }
 
-func Teardown(stack *compose.DockerCompose) {
-	err := stack.Down(
-		context.Background(),
-		compose.RemoveOrphans(true),
-		compose.RemoveImagesLocal,
-	)
-	if err != nil {
-		log.Printf("Failed to start stack: %v", err)
+var stackCount atomic.Int32
+
+var slugUnsafe = regexp.MustCompile(`[^a-z0-9]+`)
+
+// slug turns text into at most max lowercase letters, digits and dashes, safe for project and file names
+func slug(text string, max int) string {
+	text = strings.Trim(slugUnsafe.ReplaceAllString(strings.ToLower(text), "-"), "-")
+	if len(text) > max {
+		text = strings.TrimRight(text[:max], "-")
+	}
+	return text
+}
+
+// stackIdentifier names a compose project after the suite process, the ginkgo process and the top-level container.
+// Compose prefixes the networks and volumes of a stack with its project, so no two stacks share any of them.
+func stackIdentifier() string {
+	scope := "suite"
+	if texts := CurrentSpecReport().ContainerHierarchyTexts; len(texts) > 0 {
+		scope = texts[0]
+	}
+	return projectName(GinkgoParallelProcess(), scope)
+}
+
+func projectName(process int, scope string) string {
+	return fmt.Sprintf("dfmc-%d-p%d-%s-%d", os.Getpid(), process, slug(scope, 24), stackCount.Add(1))
+}
 
+// Teardown stops the forward and removes the stack with its volumes, only the first call does anything
+func Teardown(target *Target) {
+	if target == nil {
+		return
 	}
+	target.teardown.Do(func() {
+		if target.forward != nil {
+			target.forward.Close()
+		}
+		if target.Stack == nil {
+			return
+		}
+		untrackStack(target)
+		err := target.Stack.Down(
+			context.Background(),
+			compose.RemoveOrphans(true),
+			compose.RemoveVolumes(true),
+			compose.RemoveImagesLocal,
+		)
+		if err != nil {
+			log.Printf("Failed to stop stack: %v", err)
+		}
+	})
 }
 
 func AddPlotAuth(ctx context.Context, name string, plotId int32) context.Context {
@@ -133,63 +422,18 @@ func AddPlotAuth(ctx context.Context, name string, plotId int32) context.Context
 
 func StrAsRef(s string) *string { return &s }
 
-func SetupContex(port *nat.Port) context.Context {
-	ctx := context.WithValue(context.Background(), openapi.ContextServerVariables, map[string]string{
-		"port": port.Port(),
-	})
-	return context.WithValue(ctx, openapi.ContextServerIndex, 0)
+// EncodedPublicKey is the public key of key the way the protocol writes it, url-safe base64 without padding.
+// Instances are introduced, looked up and reported under it, and plots name the instance they belong to with it.
+func EncodedPublicKey(key ed25519.PrivateKey) string {
+	return base64.RawURLEncoding.EncodeToString(key.Public().(ed25519.PublicKey))
 }
 
-func SetupMockServer(key ed25519.PrivateKey) (string, string, *atomic.Int32, *httptest.Server) {
-	pubkey := key.Public().(ed25519.PublicKey)
-	encodedPubkey := base64.RawURLEncoding.EncodeToString(pubkey)
-	var hits atomic.Int32
-	addrChan := make(chan string, 1)
-	listener, err := net.Listen("tcp", "0.0.0.0:0")
-	Expect(err).ShouldNot(HaveOccurred())
-	ts := &httptest.Server{
-		Listener: listener,
-		Config:   &http.Server{Handler: http.HandlerFunc(compliantHandleIdentifyInstanceOwnership(key, addrChan, encodedPubkey, &hits))},
-	}
-	ts.Start()
-	unprocessedAddr := listener.Addr()
-	tcpAddr, ok := unprocessedAddr.(*net.TCPAddr)
-	Expect(ok).Should(BeTrue())
-	mockAddr := fmt.Sprintf("host.docker.internal:%d", tcpAddr.Port)
-	addrChan <- mockAddr
-
-	log.Printf("Mock address: http://%s", mockAddr)
-	return encodedPubkey, mockAddr, &hits, ts
-}
-
-func compliantHandleIdentifyInstanceOwnership(key ed25519.PrivateKey, addrChan chan string, pubkey string, hits *atomic.Int32) func(w http.ResponseWriter, r *http.Request) {
-	// Yes, this is just a dfmailbox complianct /v0/federation/instance
-	return func(w http.ResponseWriter, r *http.Request) {
-		// This is probably not how you are supposed to do this
-		// But I shoulnd't dwell on this too long and I should come back when I am better at go
-		addr := <-addrChan
-		addrChan <- addr
-
-		Expect(r.URL.Path).Should(Equal("/v0/federation/instance"))
-		Expect(r.Method, "GET")
-		query := r.URL.Query()
-		challengeStrUuid := query.Get("challenge")
-		challengeUuid, err := uuid.Parse(challengeStrUuid)
-		Expect(err).ShouldNot(HaveOccurred())
-		Expect(uuidRegex.Match([]byte(challengeStrUuid))).Should(BeTrue())
-		challengeBytes := challengeUuid[:]
-		challenge := append([]byte(addr), challengeBytes...)
-		sig := ed25519.Sign(key, challenge)
-		encoded, err := json.Marshal(
-			openapi.VerifyIdentity200Response{
-				PublicKey: pubkey,
-				Signature: base64.RawStdEncoding.EncodeToString(sig),
-				Address:   addr,
-			},
-		)
-		w.Header().Set("Content-Type", "application/json")
-		_, err = w.Write(encoded)
-		Expect(err).ShouldNot(HaveOccurred())
-		hits.Add(1)
-	}
+// ExpectUnknownInstance checks that the target has no record of key, e.g. after an introduction failed
+func ExpectUnknownInstance(client *openapi.APIClient, ctx context.Context, key ed25519.PrivateKey) {
+	GinkgoHelper()
+	_, resp, err := client.InstanceAPI.LookupInstanceAddress(ctx).
+		PublicKey(EncodedPublicKey(key)).
+		Execute()
+	Expect(err).Should(HaveOccurred(), "the instance stored a record of a peer it should have rejected")
+	Expect(resp).Should(BeProblem(ProblemUnknownInstance, 404))
 }
@@ -0,0 +1,111 @@
+package tests
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	openapi "github.com/DFMailbox/go-client"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+// Nothing a peer says during a challenge should be taken at face value
+var _ = Describe("Introducing a hostile instance", Ordered, Label("federation", "hostile"), func() {
+	var client *openapi.APIClient
+	var ctx context.Context
+	var target *Target
+	BeforeAll(func() {
+		t, err := SetupDefault()
+		target = t
+		Expect(err).ShouldNot(HaveOccurred())
+
+		ctx = target.Context()
+		log.Printf("Container address: %s/", target.URL)
+		client = target.Client()
+	})
+
+	introduce := func(peer *MockPeer) *http.Response {
+		// Every instance has to give up on a peer eventually, 30 seconds is generous
+		ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+		defer cancel()
+		resp, err := client.InstanceAPI.IntroduceInstance(ctx).IntroduceInstanceRequest(
+			*openapi.NewIntroduceInstanceRequest(peer.PublicKey(), peer.Address),
+		).Execute()
+		Expect(err).Should(HaveOccurred())
+		Expect(resp).ShouldNot(BeNil(), "the instance did not answer within 30 seconds")
+		return resp
+	}
+
+	It("should reject a signature made with another key", Requirement("FED-HOSTILE-001"), func() {
+		rejected, wrong := TestKey("rejected"), TestKey("wrong")
+		peer := NewMockPeer(rejected, WrongSignature(wrong))
+		resp := introduce(peer)
+		Expect(peer.Len()).Should(Equal(1))
+		challenge := peer.ExpectChallenge()
+		Expect(resp).Should(BeProblem(ProblemChallengeFailed, 400).
+			WithExtension("challenge_bytes", base64.RawStdEncoding.EncodeToString(ChallengeBytes(peer.Address, challenge))))
+		ExpectUnknownInstance(client, ctx, rejected)
+	})
+	It("should reject a signature over another address", Requirement("FED-HOSTILE-002"), func() {
+		rejected := TestKey("rejected")
+		peer := NewMockPeer(rejected, SignAddress("dfm.example.com"))
+		resp := introduce(peer)
+		Expect(peer.Len()).Should(Equal(1))
+		challenge := peer.ExpectChallenge()
+		Expect(resp).Should(BeProblem(ProblemChallengeFailed, 400).
+			WithExtension("challenge_bytes", base64.RawStdEncoding.EncodeToString(ChallengeBytes(peer.Address, challenge))))
+		ExpectUnknownInstance(client, ctx, rejected)
+	})
+	It("should tolerate a slow but compliant instance", Requirement("FED-HOSTILE-003"), func() {
+		peer := NewMockPeer(TestKey("compliant"), Delay(2*time.Second))
+		resp, err := client.InstanceAPI.IntroduceInstance(ctx).IntroduceInstanceRequest(
+			*openapi.NewIntroduceInstanceRequest(peer.PublicKey(), peer.Address),
+		).Execute()
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(resp.StatusCode).Should(Equal(200))
+		Expect(peer.Len()).Should(Equal(1))
+	})
+	It("should give up on an instance that never answers", Requirement("FED-HOSTILE-004"), Label("slow"), func() {
+		rejected := TestKey("rejected")
+		peer := NewMockPeer(rejected, Hang())
+		resp := introduce(peer)
+		Expect(peer.Len()).Should(Equal(1))
+		Expect(resp).Should(BeProblem(ProblemInstanceUnreachable, 400).WithExtension("address", peer.Address))
+		ExpectUnknownInstance(client, ctx, rejected)
+	})
+	It("should reject an instance that resets the connection", Requirement("FED-HOSTILE-005"), func() {
+		rejected := TestKey("rejected")
+		peer := NewMockPeer(rejected, ResetConnection())
+		resp := introduce(peer)
+		Expect(resp).Should(BeProblem(ProblemInstanceUnreachable, 400).WithExtension("address", peer.Address))
+		ExpectUnknownInstance(client, ctx, rejected)
+	})
+	DescribeTable("should reject an instance that answers with garbage",
+		func(behaviour PeerBehaviour) {
+			rejected := TestKey("rejected")
+			peer := NewMockPeer(rejected, behaviour)
+			resp := introduce(peer)
+			Expect(peer.Len()).Should(BeNumerically(">=", 1))
+			Expect(resp).Should(BeProblem(ProblemNonCompliance, 400).WithExtension("address", peer.Address))
+			ExpectUnknownInstance(client, ctx, rejected)
+		},
+		Entry("a server error", Requirement("FED-HOSTILE-006"), RespondStatus(http.StatusInternalServerError)),
+		Entry("an unavailable error", Requirement("FED-HOSTILE-007"), RespondStatus(http.StatusServiceUnavailable)),
+		Entry("malformed JSON", Requirement("FED-HOSTILE-008"), MalformedJSON()),
+		Entry("a 4 MiB body", Requirement("FED-HOSTILE-009"), Oversized(4<<20)),
+	)
+	It("should not follow a redirect", Requirement("FED-HOSTILE-010"), func() {
+		rejected := TestKey("rejected")
+		other := NewMockPeer(rejected)
+		peer := NewMockPeer(rejected, RedirectTo(fmt.Sprintf("http://%s", other.Address)))
+		resp := introduce(peer)
+		Expect(peer.Len()).Should(Equal(1))
+		Expect(other.Len()).Should(Equal(0), "the instance followed the redirect")
+		Expect(resp).Should(BeProblem(ProblemNonCompliance, 400).WithExtension("address", peer.Address))
+		ExpectUnknownInstance(client, ctx, rejected)
+	})
+})
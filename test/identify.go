@@ -0,0 +1,245 @@
+package tests
+
+import (
+	"context"
+	"encoding/base64"
+	"log"
+	"net/http"
+
+	openapi "github.com/DFMailbox/go-client"
+	"github.com/google/uuid"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Identify and test category: instance", Ordered, Label("federation"), func() {
+	var client *openapi.APIClient
+	var ctx context.Context
+	var target *Target
+	BeforeAll(func() {
+		// setup
+		t, err := SetupDefault()
+		target = t
+		Expect(err).ShouldNot(HaveOccurred())
+
+		ctx = target.Context()
+		log.Printf("Container address: %s/", target.URL)
+
+		client = target.Client()
+	})
+	When("The instance is compliant", func() {
+		var peer, bPeer *MockPeer
+		BeforeAll(func() {
+			key := TestKey("compliant")
+			peer = NewMockPeer(key)
+			bPeer = NewMockPeer(key)
+		})
+		It("should identify instance", Requirement("FED-IDENTIFY-001"), func() {
+			resp, err := client.InstanceAPI.IntroduceInstance(ctx).IntroduceInstanceRequest(
+				*openapi.NewIntroduceInstanceRequest(peer.PublicKey(), peer.Address),
+			).Execute()
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(resp.StatusCode).Should(Equal(200))
+			Expect(peer.Len()).Should(Equal(1))
+		})
+		It("should reject second instance registration", Requirement("FED-IDENTIFY-002"), func() {
+			resp, err := client.InstanceAPI.IntroduceInstance(ctx).IntroduceInstanceRequest(
+				*openapi.NewIntroduceInstanceRequest(peer.PublicKey(), peer.Address),
+			).Execute()
+			Expect(err).Should(HaveOccurred())
+			Expect(peer.Len()).Should(Equal(2))
+			Expect(resp).Should(BeProblem(ProblemAlreadyExists, 409))
+		})
+		It("should respond with instance", Requirement("FED-LOOKUP-001"), func() {
+			oai, resp, err := client.InstanceAPI.LookupInstanceAddress(ctx).
+				PublicKey(peer.PublicKey()).
+				Execute()
+			Expect(resp.Header.Get("content-type")).Should(Equal("application/json; charset=utf-8"))
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(resp.StatusCode).Should(Equal(200))
+			Expect(*oai.LookupInstanceAddress200ResponseOneOf.Instance.Address.Get()).Should(Equal(peer.Address))
+		})
+		It("should update instance", Requirement("FED-UPDATE-001"), func() {
+			yes := true
+			req := client.InstanceAPI.IntroduceInstance(ctx).IntroduceInstanceRequest(
+				*&openapi.IntroduceInstanceRequest{
+					PublicKey: bPeer.PublicKey(),
+					Address:   bPeer.Address,
+					Update:    &yes,
+				},
+			)
+			resp, err := req.Execute()
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(resp.StatusCode).Should(Equal(200))
+			Expect(bPeer.Len()).Should(Equal(1))
+		})
+		It("should send a fresh random challenge every time", Requirement("FED-PEER-001"), func() {
+			seen := map[uuid.UUID]bool{}
+			for _, req := range peer.Requests() {
+				Expect(req.Method).Should(Equal(http.MethodGet))
+				Expect(req.Path).Should(Equal("/v0/federation/instance"))
+				Expect(req.Query).Should(HaveLen(1), "the challenge is the only parameter")
+				challenge, err := uuid.Parse(req.Query.Get("challenge"))
+				Expect(err).ShouldNot(HaveOccurred())
+				Expect(challenge.Version()).Should(Equal(uuid.Version(4)))
+				Expect(challenge.Variant()).Should(Equal(uuid.RFC4122))
+				Expect(seen).ShouldNot(HaveKey(challenge), "the instance reused a challenge")
+				seen[challenge] = true
+			}
+			Expect(seen).Should(HaveLen(2))
+		})
+		It("should not pass credentials on to a peer", Requirement("FED-PEER-002"), func() {
+			for _, req := range append(peer.Requests(), bPeer.Requests()...) {
+				// Plots authenticate with the user agent, a peer must never see one it could replay
+				Expect(req.Header.Get("User-Agent")).ShouldNot(HavePrefix("Hypercube/"))
+				Expect(req.Header).ShouldNot(HaveKey("Authorization"))
+				Expect(req.Header).ShouldNot(HaveKey("Proxy-Authorization"))
+				Expect(req.Header).ShouldNot(HaveKey("Cookie"))
+				Expect(req.Body).Should(BeEmpty())
+			}
+		})
+		It("should only challenge the new address on update", Requirement("FED-PEER-003"), func() {
+			Expect(peer.Len()).Should(Equal(2), "the old address was challenged again")
+			requests := bPeer.Requests()
+			Expect(requests).Should(HaveLen(1))
+			Expect(requests[0].Method).Should(Equal(http.MethodGet))
+			Expect(requests[0].Path).Should(Equal("/v0/federation/instance"))
+		})
+	})
+	When("Other instance isn't compliant", func() {
+		// rejected and unknown must never end up identified, other is only ever claimed by a peer
+		It("should fail on a nonexistent instance", Requirement("FED-LOOKUP-002"), func() {
+			unknown := TestKey("unknown")
+			oai, resp, err := client.InstanceAPI.LookupInstanceAddress(ctx).PublicKey(EncodedPublicKey(unknown)).Execute()
+			Expect(oai).Should(BeNil())
+			Expect(err).Should(HaveOccurred())
+			Expect(resp).Should(BeProblem(ProblemUnknownInstance, 404).
+				WithExtension("public_key", EncodedPublicKey(unknown)))
+		})
+		It("should reject mismatch", Requirement("FED-IDENTIFY-003"), func() {
+			rejected := TestKey("rejected")
+			peer := NewMockPeer(rejected)
+			altAddr := "alt-" + peer.Address
+			resp, err := client.InstanceAPI.IntroduceInstance(ctx).IntroduceInstanceRequest(
+				*openapi.NewIntroduceInstanceRequest(peer.PublicKey(), altAddr),
+			).Execute()
+			Expect(err).Should(HaveOccurred())
+			Expect(peer.Len()).Should(Equal(1))
+			challenge := peer.ExpectSignedChallenge()
+			// The peer signed for its own address, the instance must have checked it against the introduced one
+			Expect(resp).Should(BeProblem(ProblemChallengeFailed, 400).
+				WithExtension("challenge_bytes", base64.RawStdEncoding.EncodeToString(ChallengeBytes(altAddr, challenge))))
+			ExpectUnknownInstance(client, ctx, rejected)
+		})
+		It("should reject an instance without the federation endpoint", Requirement("FED-IDENTIFY-006"), func() {
+			rejected := TestKey("rejected")
+			peer := NewMockPeer(rejected, RespondStatus(http.StatusNotFound))
+			resp, err := client.InstanceAPI.IntroduceInstance(ctx).IntroduceInstanceRequest(
+				*openapi.NewIntroduceInstanceRequest(peer.PublicKey(), peer.Address),
+			).Execute()
+			Expect(err).Should(HaveOccurred())
+			Expect(peer.Len()).Should(Equal(1))
+			peer.ExpectChallenge()
+			Expect(resp).Should(BeProblem(ProblemNonCompliance, 400).WithExtension("address", peer.Address))
+			ExpectUnknownInstance(client, ctx, rejected)
+		})
+		It("should reject a signature that isn't base64", Requirement("FED-IDENTIFY-007"), func() {
+			rejected := TestKey("rejected")
+			garble := func(w http.ResponseWriter, r *http.Request, answer *IdentityAnswer) bool {
+				answer.Body.Signature = "not a signature!"
+				return false
+			}
+			peer := NewMockPeer(rejected, garble)
+			resp, err := client.InstanceAPI.IntroduceInstance(ctx).IntroduceInstanceRequest(
+				*openapi.NewIntroduceInstanceRequest(peer.PublicKey(), peer.Address),
+			).Execute()
+			Expect(err).Should(HaveOccurred())
+			Expect(peer.Len()).Should(Equal(1))
+			peer.ExpectChallenge()
+			Expect(resp).Should(BeProblem(ProblemNonCompliance, 400).WithExtension("address", peer.Address))
+			ExpectUnknownInstance(client, ctx, rejected)
+		})
+		It("should reject an instance reporting another address", Requirement("FED-IDENTIFY-008"), func() {
+			rejected := TestKey("rejected")
+			peer := NewMockPeer(rejected, ReportAddress("dfm.example.org"))
+			resp, err := client.InstanceAPI.IntroduceInstance(ctx).IntroduceInstanceRequest(
+				*openapi.NewIntroduceInstanceRequest(peer.PublicKey(), peer.Address),
+			).Execute()
+			Expect(err).Should(HaveOccurred())
+			Expect(peer.Len()).Should(Equal(1))
+			peer.ExpectSignedChallenge()
+			Expect(resp).Should(BeProblem(ProblemMismatchedAddress, 400).
+				WithExtension("expected", peer.Address).
+				WithExtension("received", "dfm.example.org"))
+			ExpectUnknownInstance(client, ctx, rejected)
+		})
+		It("should reject an instance reporting another public key", Requirement("FED-IDENTIFY-009"), func() {
+			rejected, other := TestKey("rejected"), TestKey("other")
+			otherKey := EncodedPublicKey(other)
+			peer := NewMockPeer(rejected, ReportPublicKey(otherKey))
+			resp, err := client.InstanceAPI.IntroduceInstance(ctx).IntroduceInstanceRequest(
+				*openapi.NewIntroduceInstanceRequest(peer.PublicKey(), peer.Address),
+			).Execute()
+			Expect(err).Should(HaveOccurred())
+			Expect(peer.Len()).Should(Equal(1))
+			peer.ExpectSignedChallenge()
+			Expect(resp).Should(BeProblem(ProblemMismatchedPublicKey, 400).
+				WithExtension("expected", peer.PublicKey()).
+				WithExtension("received", otherKey))
+			ExpectUnknownInstance(client, ctx, rejected)
+			ExpectUnknownInstance(client, ctx, other)
+		})
+		It("should update instance", Requirement("FED-UPDATE-002"), func() {
+			peer := NewMockPeer(TestKey("unknown"))
+			yes := true
+			req := client.InstanceAPI.IntroduceInstance(ctx).IntroduceInstanceRequest(
+				*&openapi.IntroduceInstanceRequest{
+					PublicKey: peer.PublicKey(),
+					Address:   peer.Address,
+					Update:    &yes,
+				},
+			)
+			resp, err := req.Execute()
+			Expect(err).Should(HaveOccurred())
+			Expect(peer.Len()).Should(Equal(1))
+			Expect(resp).Should(BeProblem(ProblemNoEffectUpdate, 409))
+		})
+		It("should reject unreachable instance", Requirement("FED-IDENTIFY-004"), func() {
+			unknown := TestKey("unknown")
+			pub := EncodedPublicKey(unknown)
+			resp, err := client.InstanceAPI.IntroduceInstance(ctx).IntroduceInstanceRequest(
+				// if 4242 it responds, it means the instance is non compliant lol
+				*openapi.NewIntroduceInstanceRequest(pub, "localhost:4242"),
+			).Execute()
+			Expect(err).Should(HaveOccurred())
+			Expect(resp).Should(BeProblem(ProblemInstanceUnreachable, 400).
+				WithExtension("address", "localhost:4242"))
+			ExpectUnknownInstance(client, ctx, unknown)
+		})
+	})
+	When("Instance is compliance and using alternate key", func() {
+		var peer *MockPeer
+		BeforeAll(func() {
+			peer = NewMockPeer(TestKey("alternate"))
+		})
+		It("should identify instance with key 2", Requirement("FED-IDENTIFY-005"), func() {
+			resp, err := client.InstanceAPI.IntroduceInstance(ctx).IntroduceInstanceRequest(
+				*openapi.NewIntroduceInstanceRequest(peer.PublicKey(), peer.Address),
+			).Execute()
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(resp.StatusCode).Should(Equal(200))
+			Expect(peer.Len()).Should(Equal(1))
+		})
+		It("should respond with instance", Requirement("FED-LOOKUP-003"), func() {
+			oai, _, err := client.InstanceAPI.LookupInstanceAddress(ctx).Execute()
+			Expect(err).ShouldNot(HaveOccurred())
+			oai, resp, err := client.InstanceAPI.LookupInstanceAddress(ctx).
+				PublicKey(peer.PublicKey()).
+				Execute()
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(resp.Header.Get("content-type")).Should(Equal("application/json; charset=utf-8"))
+			Expect(resp.StatusCode).Should(Equal(200))
+			Expect(*oai.LookupInstanceAddress200ResponseOneOf.Instance.Address.Get()).Should(Equal(peer.Address))
+		})
+	})
+})
@@ -0,0 +1,93 @@
+package tests
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	. "github.com/onsi/ginkgo/v2"
+	"github.com/onsi/ginkgo/v2/types"
+)
+
+// DefaultKeySeed is what keys are derived from unless DFMC_KEY_SEED says otherwise
+const DefaultKeySeed = "dfmc"
+
+const keyEntry = "test key"
+
+// AllocatedKey is a key TestKey handed out, as recorded in the report
+type AllocatedKey struct {
+	// Full text of the spec the key belongs to, or the BeforeAll and its containers
+	Scope     string `json:"scope"`
+	Name      string `json:"name"`
+	PublicKey string `json:"public_key"`
+}
+
+func (k AllocatedKey) String() string {
+	return fmt.Sprintf("%s: %s (in %q)", k.Name, k.PublicKey, k.Scope)
+}
+
+// TestKey derives the Ed25519 key called name from the key seed and the scope it is allocated in.
+// Keys allocated in a BeforeAll belong to it and are shared by the specs of its container,
+// any other node allocates for the spec running it, so two specs never share a key by accident.
+// The same seed, scope and name always give the same key, whichever specs are focused,
+// so a failed run can be replayed with its seed. The key is recorded on the running spec.
+func TestKey(name string) ed25519.PrivateKey {
+	scope := keyScope(CurrentSpecReport())
+	env := CurrentEnv()
+	seed := sha256.Sum256(fmt.Appendf(nil, "%s\x00%s\x00%s", env.KeySeed, scope, name))
+	key := ed25519.NewKeyFromSeed(seed[:])
+	AddReportEntry(keyEntry, AllocatedKey{
+		Scope:     scope,
+		Name:      name,
+		PublicKey: EncodedPublicKey(key),
+	}, ReportEntryVisibilityFailureOrVerbose)
+	return key
+}
+
+// keyScope names the BeforeAll running for the spec, or else the spec itself
+func keyScope(spec types.SpecReport) string {
+	for i := len(spec.SpecEvents) - 1; i >= 0; i-- {
+		event := spec.SpecEvents[i]
+		if event.SpecEventType != types.SpecEventNodeStart {
+			continue
+		}
+		if event.NodeType == types.NodeTypeBeforeAll {
+			// A container may have several, and containers in different files may share their text
+			return fmt.Sprintf("%s (BeforeAll at %s:%d)", strings.Join(spec.ContainerHierarchyTexts, " "),
+				filepath.Base(event.CodeLocation.FileName), event.CodeLocation.LineNumber)
+		}
+		break
+	}
+	return spec.FullText()
+}
+
+// AllocatedKeys lists every key the specs in the report were handed, sorted by scope and name
+func AllocatedKeys(report types.Report) []AllocatedKey {
+	seen := map[AllocatedKey]bool{}
+	out := []AllocatedKey{}
+	for _, spec := range report.SpecReports {
+		for _, entry := range spec.ReportEntries {
+			if entry.Name != keyEntry {
+				continue
+			}
+			// The raw value doesn't survive a parallel run, the JSON does
+			key, ok := entry.GetRawValue().(AllocatedKey)
+			if !ok && json.Unmarshal([]byte(entry.Value.AsJSON), &key) != nil || seen[key] {
+				continue
+			}
+			seen[key] = true
+			out = append(out, key)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Scope != out[j].Scope {
+			return out[i].Scope < out[j].Scope
+		}
+		return out[i].Name < out[j].Name
+	})
+	return out
+}
@@ -0,0 +1,222 @@
+package tests
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	openapi "github.com/DFMailbox/go-client"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+// MailboxMessage is a message as a mailbox hands it out
+type MailboxMessage struct {
+	Id     int64           `json:"id"`
+	From   MailboxSender   `json:"from"`
+	Data   json.RawMessage `json:"data"`
+	SentAt time.Time       `json:"sent_at"`
+}
+
+// MailboxSender is the plot a message came from, PublicKey is nil when that plot lives on the same instance
+type MailboxSender struct {
+	PlotId    int32   `json:"plot_id"`
+	PublicKey *string `json:"public_key"`
+}
+
+// MailboxPage is one poll of a mailbox. MailboxMsgId is the id of the newest message ever enqueued.
+type MailboxPage struct {
+	Messages     []MailboxMessage `json:"messages"`
+	MailboxMsgId int64            `json:"mailbox_msg_id"`
+}
+
+// DecodeJSON decodes the body of a response the generated client doesn't know, failing the spec if it can't
+func DecodeJSON[T any](resp *http.Response) T {
+	GinkgoHelper()
+	var out T
+	Expect(resp.Header.Get("Content-Type")).Should(Equal("application/json; charset=utf-8"))
+	Expect(json.NewDecoder(resp.Body).Decode(&out)).Should(Succeed())
+	return out
+}
+
+// SendMessage enqueues data into the mailbox of plot as the plot authenticated in ctx, returning the new message id
+func SendMessage(target *Target, ctx context.Context, plot int32, data any) int64 {
+	GinkgoHelper()
+	resp, err := target.Do(ctx, http.MethodPost, fmt.Sprintf("/v0/mailbox/%d", plot), map[string]any{"data": data})
+	Expect(err).ShouldNot(HaveOccurred())
+	Expect(resp.StatusCode).Should(Equal(201))
+	return DecodeJSON[struct {
+		Id int64 `json:"id"`
+	}](resp).Id
+}
+
+// ReadMailbox polls the mailbox of the plot authenticated in ctx, query is appended as is
+func ReadMailbox(target *Target, ctx context.Context, plot int32, query string) MailboxPage {
+	GinkgoHelper()
+	resp, err := target.Do(ctx, http.MethodGet, fmt.Sprintf("/v0/mailbox/%d%s", plot, query), nil)
+	Expect(err).ShouldNot(HaveOccurred())
+	Expect(resp.StatusCode).Should(Equal(200))
+	return DecodeJSON[MailboxPage](resp)
+}
+
+// A proposal, the client has no /v0/mailbox endpoints yet
+var _ = Describe("Mailboxes", Ordered, Label("mailbox", ProposalLabel), func() {
+	var ctx context.Context
+	var client *openapi.APIClient
+	var target *Target
+	// Notch receives, jeb_ sends and dinnerbone snoops
+	var notch, jeb, dinnerbone context.Context
+	const inbox, outbox, snoop = 3001, 3002, 3003
+	var sent []int64
+	BeforeAll(func() {
+		t, err := SetupDefault()
+		target = t
+		Expect(err).ShouldNot(HaveOccurred())
+
+		ctx = target.Context()
+		client = target.Client()
+		RegisterCheckPlot(client, ctx, "Notch", "069a79f4-44e9-4726-a5be-fca90e38aaf5", inbox)
+		RegisterCheckPlot(client, ctx, "jeb_", "853c80ef-3c37-49fd-aa49-938b674adae6", outbox)
+		RegisterCheckPlot(client, ctx, "dinnerbone", "61699b2e-d327-4a01-9f1e-0ea8c3f06bc6", snoop)
+		notch = AddPlotAuth(ctx, "Notch", inbox)
+		jeb = AddPlotAuth(ctx, "jeb_", outbox)
+		dinnerbone = AddPlotAuth(ctx, "dinnerbone", snoop)
+	})
+	mailboxMsgId := func() int64 {
+		GinkgoHelper()
+		plot, _, err := client.PlotAPI.GetPlotInfo(notch).Execute()
+		Expect(err).ShouldNot(HaveOccurred())
+		return plot.MailboxMsgId
+	}
+
+	Describe("Sending", Ordered, func() {
+		It("will enqueue messages with increasing ids", Requirement("MAIL-SEND-001"), func() {
+			last := mailboxMsgId()
+			Expect(last).Should(BeZero())
+			for i := range 3 {
+				id := SendMessage(target, jeb, inbox, map[string]any{"n": i})
+				Expect(id).Should(BeNumerically(">", last))
+				Expect(mailboxMsgId()).Should(Equal(id), "mailbox_msg_id should point at the newest message")
+				last = id
+				sent = append(sent, id)
+			}
+		})
+		It("will not enqueue into an unregistered plot", Requirement("MAIL-SEND-002"), func() {
+			resp, err := target.Do(jeb, http.MethodPost, "/v0/mailbox/3999", map[string]any{"data": "hello"})
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(resp).Should(BeProblem(ProblemUnknownPlot, 404).WithExtension("plot_id", 3999))
+		})
+		It("will not enqueue without auth", Requirement("MAIL-SEND-003"), func() {
+			resp, err := target.Do(ctx, http.MethodPost, fmt.Sprintf("/v0/mailbox/%d", inbox), map[string]any{"data": "hello"})
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(resp).Should(BeProblem(ProblemUnauthorized, 401))
+			Expect(mailboxMsgId()).Should(Equal(sent[len(sent)-1]))
+		})
+	})
+	Describe("Reading", Ordered, func() {
+		It("will hand out messages oldest first", Requirement("MAIL-READ-001"), func() {
+			page := ReadMailbox(target, notch, inbox, "")
+			Expect(page.MailboxMsgId).Should(Equal(sent[len(sent)-1]))
+			Expect(page.Messages).Should(HaveLen(len(sent)))
+			for i, msg := range page.Messages {
+				Expect(msg.Id).Should(Equal(sent[i]))
+				Expect(msg.From).Should(Equal(MailboxSender{PlotId: outbox}))
+				Expect(msg.Data).Should(MatchJSON(fmt.Sprintf(`{"n": %d}`, i)))
+				Expect(msg.SentAt).Should(BeTemporally("~", time.Now(), time.Minute))
+			}
+		})
+		It("will page through messages with a cursor", Requirement("MAIL-READ-002"), func() {
+			seen := []int64{}
+			cursor := int64(0)
+			for range len(sent) + 1 {
+				page := ReadMailbox(target, notch, inbox, fmt.Sprintf("?after=%d&limit=1", cursor))
+				if len(page.Messages) == 0 {
+					break
+				}
+				Expect(page.Messages).Should(HaveLen(1))
+				seen = append(seen, page.Messages[0].Id)
+				cursor = page.Messages[0].Id
+			}
+			Expect(seen).Should(Equal(sent))
+		})
+		It("will hand out a single message by id", Requirement("MAIL-READ-003"), func() {
+			resp, err := target.Do(notch, http.MethodGet, fmt.Sprintf("/v0/mailbox/%d/%d", inbox, sent[1]), nil)
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(resp.StatusCode).Should(Equal(200))
+			msg := DecodeJSON[MailboxMessage](resp)
+			Expect(msg.Id).Should(Equal(sent[1]))
+			Expect(msg.Data).Should(MatchJSON(`{"n": 1}`))
+
+			missing := sent[len(sent)-1] + 1000
+			resp, err = target.Do(notch, http.MethodGet, fmt.Sprintf("/v0/mailbox/%d/%d", inbox, missing), nil)
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(resp).Should(BeProblem(ProblemNotFound, 404))
+		})
+		DescribeTable("will reject a page size out of range",
+			func(limit int) {
+				resp, err := target.Do(notch, http.MethodGet, fmt.Sprintf("/v0/mailbox/%d?limit=%d", inbox, limit), nil)
+				Expect(err).ShouldNot(HaveOccurred())
+				Expect(resp).Should(BeProblem(ProblemBadRequest, 400).WithExtension("parameter", "limit"))
+			},
+			Entry("of zero", Requirement("MAIL-READ-004"), 0),
+			Entry("over a thousand", Requirement("MAIL-READ-005"), 1001),
+		)
+	})
+	Describe("Removing", Ordered, func() {
+		It("will acknowledge every message up to an id", Requirement("MAIL-ACK-001"), func() {
+			resp, err := target.Do(notch, http.MethodDelete, fmt.Sprintf("/v0/mailbox/%d?until=%d", inbox, sent[0]), nil)
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(resp.StatusCode).Should(Equal(204))
+
+			page := ReadMailbox(target, notch, inbox, "")
+			Expect(page.Messages).Should(HaveLen(len(sent) - 1))
+			Expect(page.Messages[0].Id).Should(Equal(sent[1]))
+			Expect(page.MailboxMsgId).Should(Equal(sent[len(sent)-1]), "acknowledging must not rewind mailbox_msg_id")
+		})
+		It("will delete a single message", Requirement("MAIL-DELETE-001"), func() {
+			path := fmt.Sprintf("/v0/mailbox/%d/%d", inbox, sent[1])
+			resp, err := target.Do(notch, http.MethodDelete, path, nil)
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(resp.StatusCode).Should(Equal(204))
+
+			page := ReadMailbox(target, notch, inbox, "")
+			Expect(page.Messages).Should(HaveLen(1))
+			Expect(page.Messages[0].Id).Should(Equal(sent[2]))
+
+			resp, err = target.Do(notch, http.MethodDelete, path, nil)
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(resp).Should(BeProblem(ProblemNotFound, 404))
+		})
+		It("will never reuse an id", Requirement("MAIL-ID-001"), func() {
+			resp, err := target.Do(notch, http.MethodDelete, fmt.Sprintf("/v0/mailbox/%d?until=%d", inbox, sent[len(sent)-1]), nil)
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(resp.StatusCode).Should(Equal(204))
+			Expect(ReadMailbox(target, notch, inbox, "").Messages).Should(BeEmpty())
+
+			id := SendMessage(target, jeb, inbox, "again")
+			Expect(id).Should(BeNumerically(">", sent[len(sent)-1]))
+			Expect(mailboxMsgId()).Should(Equal(id))
+		})
+	})
+	Describe("Other plots", func() {
+		DescribeTable("can't touch someone else's mailbox",
+			func(method string, path string) {
+				resp, err := target.Do(dinnerbone, method, fmt.Sprintf(path, inbox), nil)
+				Expect(err).ShouldNot(HaveOccurred())
+				Expect(resp).Should(BeProblem(ProblemMailboxForbidden, 403).WithExtension("plot_id", inbox))
+			},
+			Entry("when reading it", Requirement("MAIL-FORBIDDEN-001"), http.MethodGet, "/v0/mailbox/%d"),
+			Entry("when reading a message", Requirement("MAIL-FORBIDDEN-002"), http.MethodGet, "/v0/mailbox/%d/1"),
+			Entry("when acknowledging it", Requirement("MAIL-FORBIDDEN-003"), http.MethodDelete, "/v0/mailbox/%d?until=1"),
+			Entry("when deleting a message", Requirement("MAIL-FORBIDDEN-004"), http.MethodDelete, "/v0/mailbox/%d/1"),
+		)
+		It("will not let an unregistered plot read a mailbox", Requirement("MAIL-FORBIDDEN-005"), func() {
+			resp, err := target.Do(AddPlotAuth(ctx, "Notch", 3999), http.MethodGet, "/v0/mailbox/3999", nil)
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(resp).Should(BeProblem(ProblemExpectedRoleAny, 403).
+				WithExtension("received", "unregistered"))
+		})
+	})
+})
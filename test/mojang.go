@@ -0,0 +1,60 @@
+package tests
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// mojangPlayers are the players the plots in the specs belong to, as api.mojang.com knows them
+var mojangPlayers = []struct{ Name, Id string }{
+	{"Notch", "069a79f444e94726a5befca90e38aaf5"},
+	{"jeb_", "853c80ef3c3749fdaa49938b674adae6"},
+	{"Dinnerbone", "61699b2ed3274a019f1e0ea8c3f06bc6"},
+}
+
+var mojangStub struct {
+	once sync.Once
+	url  string
+	err  error
+}
+
+// MojangAPI is the URL containers reach the suite's stand-in for api.mojang.com at, so resolving plot owners
+// never depends on the internet. It starts on first use and serves until the process exits.
+func MojangAPI() (string, error) {
+	mojangStub.once.Do(func() {
+		listener, err := net.Listen("tcp", "0.0.0.0:0")
+		if err != nil {
+			mojangStub.err = fmt.Errorf("Failed to listen for the Mojang API %v", err)
+			return
+		}
+		mux := http.NewServeMux()
+		mux.HandleFunc("GET /users/profiles/minecraft/{name}", handleMojangProfile)
+		go http.Serve(listener, mux)
+		mojangStub.url = fmt.Sprintf("http://host.docker.internal:%d", listener.Addr().(*net.TCPAddr).Port)
+		log.Printf("Mojang API: %s", mojangStub.url)
+	})
+	return mojangStub.url, mojangStub.err
+}
+
+// handleMojangProfile looks a name up like Mojang does, ignoring case and answering with the name as registered
+func handleMojangProfile(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	for _, player := range mojangPlayers {
+		if strings.EqualFold(player.Name, name) {
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(map[string]string{"id": player.Id, "name": player.Name})
+			return
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusNotFound)
+	json.NewEncoder(w).Encode(map[string]string{
+		"path":         r.URL.Path,
+		"errorMessage": fmt.Sprintf("Couldn't find any profile with name %s", name),
+	})
+}
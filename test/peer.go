@@ -0,0 +1,414 @@
+package tests
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	openapi "github.com/DFMailbox/go-client"
+	"github.com/google/uuid"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+// IdentityAnswer is what a mock peer is about to answer an identity challenge with
+type IdentityAnswer struct {
+	Key       ed25519.PrivateKey
+	Challenge uuid.UUID
+	Body      openapi.VerifyIdentity200Response
+}
+
+// Sign replaces the signature with one over address followed by the challenge, which is only valid for the peer's own address
+func (a *IdentityAnswer) Sign(address string) {
+	a.Body.Signature = base64.RawStdEncoding.EncodeToString(ed25519.Sign(a.Key, ChallengeBytes(address, a.Challenge)))
+}
+
+// ChallengeBytes is what an instance at address signs to answer challenge
+func ChallengeBytes(address string, challenge uuid.UUID) []byte {
+	return append([]byte(address), challenge[:]...)
+}
+
+// RecordedRequest is one request a mock peer received, kept as it arrived
+type RecordedRequest struct {
+	Method     string
+	Path       string
+	Query      url.Values
+	Header     http.Header
+	Body       []byte
+	Time       time.Time
+	RemoteAddr string
+}
+
+// RequestLog is every request a mock peer received, oldest first. It is safe to read while the peer is serving.
+type RequestLog struct {
+	mu       sync.Mutex
+	requests []RecordedRequest
+}
+
+// record appends r to the log, leaving its body readable for the handler
+func (l *RequestLog) record(r *http.Request) {
+	body, _ := io.ReadAll(io.LimitReader(r.Body, 1<<20))
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.requests = append(l.requests, RecordedRequest{
+		Method:     r.Method,
+		Path:       r.URL.Path,
+		Query:      r.URL.Query(),
+		Header:     r.Header.Clone(),
+		Body:       body,
+		Time:       time.Now(),
+		RemoteAddr: r.RemoteAddr,
+	})
+}
+
+// Requests is a copy of the log so far
+func (l *RequestLog) Requests() []RecordedRequest {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return append([]RecordedRequest(nil), l.requests...)
+}
+
+// Len is how many requests the peer received so far
+func (l *RequestLog) Len() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return len(l.requests)
+}
+
+// PeerBehaviour changes how a mock peer answers a challenge. It either rewrites the answer and returns false,
+// or writes the response itself and returns true, which stops any later behaviour.
+type PeerBehaviour func(w http.ResponseWriter, r *http.Request, answer *IdentityAnswer) bool
+
+// CompromisedBytes is what the holder of a leaked key signs to have every instance stop binding plots to it
+func CompromisedBytes(key ed25519.PublicKey) []byte {
+	return append([]byte("compromised"), key...)
+}
+
+// WrongSignature signs the challenge with a key other than the one the peer claims
+func WrongSignature(other ed25519.PrivateKey) PeerBehaviour {
+	return func(w http.ResponseWriter, r *http.Request, answer *IdentityAnswer) bool {
+		answer.Key = other
+		answer.Sign(answer.Body.Address)
+		return false
+	}
+}
+
+// SignAddress signs the challenge for an address that isn't the one the peer was introduced with
+func SignAddress(address string) PeerBehaviour {
+	return func(w http.ResponseWriter, r *http.Request, answer *IdentityAnswer) bool {
+		answer.Sign(address)
+		return false
+	}
+}
+
+// ReportPublicKey puts a different public key in the body, the signature stays valid for the real one
+func ReportPublicKey(key string) PeerBehaviour {
+	return func(w http.ResponseWriter, r *http.Request, answer *IdentityAnswer) bool {
+		answer.Body.PublicKey = key
+		return false
+	}
+}
+
+// ReportAddress puts a different address in the body, the signature stays valid for the real one
+func ReportAddress(address string) PeerBehaviour {
+	return func(w http.ResponseWriter, r *http.Request, answer *IdentityAnswer) bool {
+		answer.Body.Address = address
+		return false
+	}
+}
+
+// Replay answers every challenge with the answer to the first one, as someone who sniffed a single answer would
+func Replay() PeerBehaviour {
+	var mu sync.Mutex
+	var first *openapi.VerifyIdentity200Response
+	return func(w http.ResponseWriter, r *http.Request, answer *IdentityAnswer) bool {
+		mu.Lock()
+		defer mu.Unlock()
+		if first == nil {
+			body := answer.Body
+			first = &body
+		}
+		answer.Body = *first
+		return false
+	}
+}
+
+// Once applies behaviour to the first challenge only, every later one is answered as if it wasn't there
+func Once(behaviour PeerBehaviour) PeerBehaviour {
+	var done atomic.Bool
+	return func(w http.ResponseWriter, r *http.Request, answer *IdentityAnswer) bool {
+		if done.Swap(true) {
+			return false
+		}
+		return behaviour(w, r, answer)
+	}
+}
+
+// EncodeSignature re-encodes the signature with enc, put it after any behaviour that signs
+func EncodeSignature(enc *base64.Encoding) PeerBehaviour {
+	return func(w http.ResponseWriter, r *http.Request, answer *IdentityAnswer) bool {
+		sig, err := base64.RawStdEncoding.DecodeString(answer.Body.Signature)
+		if err != nil {
+			panic(peerFailure(fmt.Sprintf("signature is not base64: %v", err)))
+		}
+		answer.Body.Signature = enc.EncodeToString(sig)
+		return false
+	}
+}
+
+// Delay answers correctly, but only after d
+func Delay(d time.Duration) PeerBehaviour {
+	return func(w http.ResponseWriter, r *http.Request, answer *IdentityAnswer) bool {
+		select {
+		case <-time.After(d):
+		case <-r.Context().Done():
+		}
+		return false
+	}
+}
+
+// Hang never answers, the instance under test has to give up on its own
+func Hang() PeerBehaviour {
+	return func(w http.ResponseWriter, r *http.Request, answer *IdentityAnswer) bool {
+		<-r.Context().Done()
+		return true
+	}
+}
+
+// RespondStatus answers with a bare status code, e.g. a 503 from a struggling peer
+func RespondStatus(status int) PeerBehaviour {
+	return func(w http.ResponseWriter, r *http.Request, answer *IdentityAnswer) bool {
+		http.Error(w, http.StatusText(status), status)
+		return true
+	}
+}
+
+// MalformedJSON answers with a body that claims to be JSON but is cut off halfway
+func MalformedJSON() PeerBehaviour {
+	return func(w http.ResponseWriter, r *http.Request, answer *IdentityAnswer) bool {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"public_key":"%s","signature":"%s`, answer.Body.PublicKey, answer.Body.Signature)
+		return true
+	}
+}
+
+// Oversized answers with a valid identity padded out to at least size bytes
+func Oversized(size int) PeerBehaviour {
+	return func(w http.ResponseWriter, r *http.Request, answer *IdentityAnswer) bool {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"public_key":"%s","signature":"%s","address":"%s","padding":"%s"}`,
+			answer.Body.PublicKey, answer.Body.Signature, answer.Body.Address, strings.Repeat("a", size))
+		return true
+	}
+}
+
+// RedirectTo sends the challenge elsewhere, a compliant instance must not follow it
+func RedirectTo(url string) PeerBehaviour {
+	return func(w http.ResponseWriter, r *http.Request, answer *IdentityAnswer) bool {
+		http.Redirect(w, r, url+r.URL.RequestURI(), http.StatusTemporaryRedirect)
+		return true
+	}
+}
+
+// ResetConnection drops the TCP connection with a RST instead of answering
+func ResetConnection() PeerBehaviour {
+	return func(w http.ResponseWriter, r *http.Request, answer *IdentityAnswer) bool {
+		hijacker, ok := w.(http.Hijacker)
+		if !ok {
+			panic(peerFailure("cannot hijack the connection to reset it"))
+		}
+		conn, _, err := hijacker.Hijack()
+		if err != nil {
+			panic(peerFailure(fmt.Sprintf("failed to hijack the connection to reset it: %v", err)))
+		}
+		if tcp, ok := conn.(*net.TCPConn); ok {
+			tcp.SetLinger(0)
+		}
+		conn.Close()
+		return true
+	}
+}
+
+// MockPeer is an instance on the host that answers identity challenges for its key and logs every request it receives.
+// Its handler never fails on the server goroutine, failed assertions are collected and fail the spec that started it once it stops.
+type MockPeer struct {
+	*RequestLog
+	// Address is how the instance under test reaches the peer
+	Address string
+
+	behaviours []PeerBehaviour
+	server     *httptest.Server
+
+	// Guards the key, which Rotate changes while the handler reads it, what the peer was challenged with and answered, and the failures
+	mu         sync.Mutex
+	key        ed25519.PrivateKey
+	publicKey  string
+	challenges []uuid.UUID
+	answers    []IdentityAnswer
+	failures   []string
+}
+
+// peerFailure is what a failed assertion in the handler panics with, so ServeHTTP can tell it from a real panic
+type peerFailure string
+
+// NewMockPeer starts a peer for key, without behaviours it is compliant.
+// It stops when the node that started it ends: the spec for an It, the container for a BeforeAll.
+func NewMockPeer(key ed25519.PrivateKey, behaviours ...PeerBehaviour) *MockPeer {
+	GinkgoHelper()
+	listener, err := net.Listen("tcp", "0.0.0.0:0")
+	Expect(err).ShouldNot(HaveOccurred())
+	peer := &MockPeer{
+		RequestLog: &RequestLog{},
+		key:        key,
+		publicKey:  EncodedPublicKey(key),
+		Address:    fmt.Sprintf("host.docker.internal:%d", listener.Addr().(*net.TCPAddr).Port),
+		behaviours: behaviours,
+	}
+	peer.server = &httptest.Server{Listener: listener, Config: &http.Server{Handler: peer}}
+	peer.server.Start()
+	DeferCleanup(peer.Stop)
+
+	log.Printf("Mock address: http://%s", peer.Address)
+	return peer
+}
+
+// ServeHTTP is a compliant /v0/federation/instance until a behaviour says otherwise
+func (p *MockPeer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	p.record(r)
+	g := NewGomega(func(message string, _ ...int) {
+		panic(peerFailure(message))
+	})
+	defer func() {
+		e := recover()
+		if e == nil {
+			return
+		}
+		failure, ok := e.(peerFailure)
+		if !ok {
+			panic(e)
+		}
+		p.mu.Lock()
+		p.failures = append(p.failures, fmt.Sprintf("%s %s: %s", r.Method, r.URL, failure))
+		p.mu.Unlock()
+		http.Error(w, "mock peer rejected the request", http.StatusInternalServerError)
+	}()
+
+	g.Expect(r.Method).Should(Equal(http.MethodGet))
+	g.Expect(r.URL.Path).Should(Equal("/v0/federation/instance"))
+	challengeStrUuid := r.URL.Query().Get("challenge")
+	g.Expect(challengeStrUuid).Should(MatchRegexp(uuidRegex.String()))
+	challengeUuid, err := uuid.Parse(challengeStrUuid)
+	g.Expect(err).ShouldNot(HaveOccurred())
+	p.mu.Lock()
+	p.challenges = append(p.challenges, challengeUuid)
+	answer := &IdentityAnswer{
+		Key:       p.key,
+		Challenge: challengeUuid,
+		Body: openapi.VerifyIdentity200Response{
+			PublicKey: p.publicKey,
+			Address:   p.Address,
+		},
+	}
+	p.mu.Unlock()
+	answer.Sign(p.Address)
+	for _, behave := range p.behaviours {
+		if behave(w, r, answer) {
+			return
+		}
+	}
+	encoded, err := json.Marshal(answer.Body)
+	g.Expect(err).ShouldNot(HaveOccurred())
+	p.mu.Lock()
+	p.answers = append(p.answers, *answer)
+	p.mu.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(encoded)
+}
+
+// Rotate makes the peer answer challenges with key from now on, as an instance that rotated its key would
+func (p *MockPeer) Rotate(key ed25519.PrivateKey) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.key = key
+	p.publicKey = EncodedPublicKey(key)
+}
+
+// Key is the key the peer answers challenges with
+func (p *MockPeer) Key() ed25519.PrivateKey {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.key
+}
+
+// PublicKey is the EncodedPublicKey of Key
+func (p *MockPeer) PublicKey() string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.publicKey
+}
+
+// Challenges is every challenge the peer received, oldest first
+func (p *MockPeer) Challenges() []uuid.UUID {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]uuid.UUID(nil), p.challenges...)
+}
+
+// Answers is every identity the peer sent back as its behaviours left it, oldest first.
+// A behaviour that writes the response itself leaves no answer.
+func (p *MockPeer) Answers() []IdentityAnswer {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]IdentityAnswer(nil), p.answers...)
+}
+
+// ExpectChallenge checks that the instance sent the peer a single random challenge and returns it
+func (p *MockPeer) ExpectChallenge() uuid.UUID {
+	GinkgoHelper()
+	challenges := p.Challenges()
+	Expect(challenges).Should(HaveLen(1), "the instance must challenge the peer exactly once")
+	Expect(challenges[0].Version()).Should(Equal(uuid.Version(4)), "the challenge must be a random UUID")
+	return challenges[0]
+}
+
+// ExpectSignedChallenge checks that the peer answered its single challenge with a signature over its own address
+// followed by the challenge, so the instance had a genuine answer to check, and returns the challenge
+func (p *MockPeer) ExpectSignedChallenge() uuid.UUID {
+	GinkgoHelper()
+	challenge := p.ExpectChallenge()
+	answers := p.Answers()
+	Expect(answers).Should(HaveLen(1), "the peer never answered its challenge")
+	Expect(answers[0].Challenge).Should(Equal(challenge))
+	sig, err := base64.RawStdEncoding.DecodeString(answers[0].Body.Signature)
+	Expect(err).ShouldNot(HaveOccurred())
+	valid := ed25519.Verify(answers[0].Key.Public().(ed25519.PublicKey), ChallengeBytes(p.Address, challenge), sig)
+	Expect(valid).Should(BeTrue(), "the peer didn't sign %s followed by the challenge", p.Address)
+	return challenge
+}
+
+// Failures is every assertion the handler failed so far
+func (p *MockPeer) Failures() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]string(nil), p.failures...)
+}
+
+// Stop waits for outstanding requests, shuts the peer down and fails the spec if the handler failed any assertion
+func (p *MockPeer) Stop() {
+	GinkgoHelper()
+	p.server.Close()
+	Expect(p.Failures()).Should(BeEmpty(), "the mock peer at %s received requests it didn't expect", p.Address)
+}
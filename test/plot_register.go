@@ -0,0 +1,317 @@
+package tests
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/base64"
+	"net/http"
+
+	openapi "github.com/DFMailbox/go-client"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Registering plots", Ordered, func() {
+	var ctx context.Context
+	var client *openapi.APIClient
+	var target *Target
+	BeforeAll(func() {
+		t, err := SetupDefault()
+		target = t
+		Expect(err).ShouldNot(HaveOccurred())
+
+		ctx = target.Context()
+		client = target.Client()
+	})
+
+	Describe("Internal plots", Ordered, func() {
+		It("will register Notch", Requirement("PLOT-REGISTER-001"), func() {
+			RegisterCheckPlot(client, ctx, "Notch", "069a79f4-44e9-4726-a5be-fca90e38aaf5", 123)
+		})
+		It("will register Jeb_", Requirement("PLOT-REGISTER-002"), func() {
+			RegisterCheckPlot(client, ctx, "jeb_", "853c80ef-3c37-49fd-aa49-938b674adae6", 456)
+		})
+		It("will register dinnerbone", Requirement("PLOT-REGISTER-003"), func() {
+			RegisterCheckPlot(client, ctx, "dinnerbone", "61699b2e-d327-4a01-9f1e-0ea8c3f06bc6", 2147483647)
+		})
+	})
+	Describe("External plots", Ordered, func() {
+		var peers [2]*MockPeer
+		var pubkeys [2]string
+		jeb := "853c80ef-3c37-49fd-aa49-938b674adae6"
+		BeforeAll(func() {
+			for i, name := range []string{"first instance", "second instance"} {
+				key := TestKey(name)
+				pubkeys[i] = EncodedPublicKey(key)
+				peers[i] = NewMockPeer(key)
+				_, err := client.InstanceAPI.IntroduceInstance(ctx).IntroduceInstanceRequest(
+					*openapi.NewIntroduceInstanceRequest(pubkeys[i], peers[i].Address),
+				).Execute()
+				Expect(err).ShouldNot(HaveOccurred())
+			}
+		})
+		moveCheckPlot := func(key *string, expected openapi.Plot) {
+			ctx := AddPlotAuth(ctx, "jeb_", expected.PlotId)
+			resp, err := client.PlotAPI.UpdateInstance(ctx).UpdateInstanceRequest(
+				*openapi.NewUpdateInstanceRequest(*openapi.NewNullableString(key)),
+			).Execute()
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(resp.StatusCode).Should(Equal(200))
+
+			plot, _, err := client.PlotAPI.GetPlotInfo(ctx).Execute()
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(*plot).Should(Equal(expected))
+		}
+
+		It("will register a plot on an identified instance", Requirement("PLOT-REGISTER-005"), func() {
+			ctx := AddPlotAuth(ctx, "jeb_", 789)
+			resp, err := client.PlotAPI.RegisterPlot(ctx).UpdateInstanceRequest(
+				*openapi.NewUpdateInstanceRequest(*openapi.NewNullableString(&pubkeys[0])),
+			).Execute()
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(resp.StatusCode).Should(Equal(201))
+
+			plot, resp, err := client.PlotAPI.GetPlotInfo(ctx).Execute()
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(resp.StatusCode).Should(Equal(200))
+			Expect(*plot).Should(Equal(openapi.Plot{
+				PlotId:       789,
+				Owner:        jeb,
+				PublicKey:    *openapi.NewNullableString(&pubkeys[0]),
+				Address:      *openapi.NewNullableString(&peers[0].Address),
+				MailboxMsgId: 0,
+			}))
+		})
+		It("will move a plot to another identified instance", Requirement("PLOT-UPDATE-001"), func() {
+			moveCheckPlot(&pubkeys[1], openapi.Plot{
+				PlotId:       789,
+				Owner:        jeb,
+				PublicKey:    *openapi.NewNullableString(&pubkeys[1]),
+				Address:      *openapi.NewNullableString(&peers[1].Address),
+				MailboxMsgId: 0,
+			})
+		})
+		It("will not move a plot to the instance it is on", Requirement("PLOT-UPDATE-002"), func() {
+			resp, err := client.PlotAPI.UpdateInstance(AddPlotAuth(ctx, "jeb_", 789)).UpdateInstanceRequest(
+				*openapi.NewUpdateInstanceRequest(*openapi.NewNullableString(&pubkeys[1])),
+			).Execute()
+			Expect(err).Should(HaveOccurred())
+			Expect(resp).Should(BeProblem(ProblemNoEffectUpdate, 409))
+		})
+		It("will not move a plot to an unidentified instance", Requirement("PLOT-UPDATE-003"), func() {
+			unknown := EncodedPublicKey(TestKey("unknown"))
+			ctx := AddPlotAuth(ctx, "jeb_", 789)
+			resp, err := client.PlotAPI.UpdateInstance(ctx).UpdateInstanceRequest(
+				*openapi.NewUpdateInstanceRequest(*openapi.NewNullableString(&unknown)),
+			).Execute()
+			Expect(err).Should(HaveOccurred())
+			Expect(resp).Should(BeProblem(ProblemUnknownInstance, 409).WithExtension("public_key", unknown))
+
+			plot, _, err := client.PlotAPI.GetPlotInfo(ctx).Execute()
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(plot.PublicKey.Get()).Should(HaveValue(Equal(pubkeys[1])))
+		})
+		It("will move a plot back to this instance", Requirement("PLOT-UPDATE-004"), func() {
+			moveCheckPlot(nil, openapi.Plot{
+				PlotId:       789,
+				Owner:        jeb,
+				PublicKey:    *openapi.NewNullableString(nil),
+				Address:      *openapi.NewNullableString(nil),
+				MailboxMsgId: 0,
+			})
+		})
+		It("will fail to register unidentfied instance", Requirement("PLOT-REGISTER-004"), func() {
+			ctx := AddPlotAuth(ctx, "NOTCH", 666)
+			pubKey := EncodedPublicKey(TestKey("unknown"))
+			resp, err := client.PlotAPI.RegisterPlot(ctx).UpdateInstanceRequest(
+				*openapi.NewUpdateInstanceRequest(*openapi.NewNullableString(&pubKey)),
+			).Execute()
+			Expect(err).Should(HaveOccurred())
+			Expect(resp).Should(BeProblem(ProblemUnknownInstance, 409).
+				WithExtension("public_key", pubKey))
+		})
+		It("will return unregistered error", Requirement("PLOT-INFO-001"), func() {
+			ctx := AddPlotAuth(ctx, "NOTCH", 666)
+			plot, resp, err := client.PlotAPI.GetPlotInfo(ctx).Execute()
+			Expect(err).Should(HaveOccurred())
+			Expect(plot).Should(BeNil())
+			Expect(resp).Should(BeProblem(ProblemExpectedRoleAny, 403).
+				WithExtension("expected", ContainElements("host", "registered")).
+				WithExtension("received", "unregistered"))
+		})
+	})
+
+	// Proposals, the client has neither DELETE /v0/plot nor POST /v0/instance/compromised
+	Describe("Deleting plots", Ordered, Label(ProposalLabel), func() {
+		It("will not delete an unregistered plot", Requirement("PLOT-DELETE-001"), func() {
+			resp, err := target.Do(AddPlotAuth(ctx, "Notch", 1001), http.MethodDelete, "/v0/plot", nil)
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(resp).Should(BeProblem(ProblemUnknownPlot, 404).WithExtension("plot_id", 1001))
+		})
+		It("will not let another player delete a plot", Requirement("PLOT-DELETE-002"), func() {
+			RegisterCheckPlot(client, ctx, "Notch", "069a79f4-44e9-4726-a5be-fca90e38aaf5", 1001)
+			resp, err := target.Do(AddPlotAuth(ctx, "jeb_", 1001), http.MethodDelete, "/v0/plot", nil)
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(resp).Should(BeProblem(ProblemPlotForbidden, 403).WithExtension("plot_id", 1001))
+
+			plot, _, err := client.PlotAPI.GetPlotInfo(AddPlotAuth(ctx, "Notch", 1001)).Execute()
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(plot.Owner).Should(Equal("069a79f4-44e9-4726-a5be-fca90e38aaf5"))
+		})
+		It("will delete a plot", Requirement("PLOT-DELETE-003"), func() {
+			ctx := AddPlotAuth(ctx, "Notch", 1001)
+			resp, err := target.Do(ctx, http.MethodDelete, "/v0/plot", nil)
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(resp.StatusCode).Should(Equal(204))
+
+			plot, resp, err := client.PlotAPI.GetPlotInfo(ctx).Execute()
+			Expect(err).Should(HaveOccurred())
+			Expect(plot).Should(BeNil())
+			Expect(resp).Should(BeProblem(ProblemExpectedRoleAny, 403).
+				WithExtension("received", "unregistered"))
+
+			resp, err = target.Do(ctx, http.MethodDelete, "/v0/plot", nil)
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(resp).Should(BeProblem(ProblemUnknownPlot, 404).WithExtension("plot_id", 1001))
+		})
+		It("will register a deleted plot from scratch", Requirement("PLOT-DELETE-004"), func() {
+			RegisterCheckPlot(client, ctx, "Notch", "069a79f4-44e9-4726-a5be-fca90e38aaf5", 1001)
+		})
+	})
+	Describe("Compromised instance keys", Ordered, Label(ProposalLabel), func() {
+		// compromised gets flagged, stranger is never introduced
+		var compromised, stranger ed25519.PrivateKey
+		var pubkey string
+		reportCompromised := func(key ed25519.PrivateKey, signer ed25519.PrivateKey) *http.Response {
+			pub := key.Public().(ed25519.PublicKey)
+			resp, err := target.Do(ctx, http.MethodPost, "/v0/instance/compromised", map[string]string{
+				"public_key": EncodedPublicKey(key),
+				"signature":  base64.RawStdEncoding.EncodeToString(ed25519.Sign(signer, CompromisedBytes(pub))),
+			})
+			Expect(err).ShouldNot(HaveOccurred())
+			return resp
+		}
+		BeforeAll(func() {
+			compromised = TestKey("compromised")
+			stranger = TestKey("stranger")
+			pubkey = EncodedPublicKey(compromised)
+			peer := NewMockPeer(compromised)
+			_, err := client.InstanceAPI.IntroduceInstance(ctx).IntroduceInstanceRequest(
+				*openapi.NewIntroduceInstanceRequest(pubkey, peer.Address),
+			).Execute()
+			Expect(err).ShouldNot(HaveOccurred())
+
+			resp, err := client.PlotAPI.RegisterPlot(AddPlotAuth(ctx, "Notch", 2001)).UpdateInstanceRequest(
+				*openapi.NewUpdateInstanceRequest(*openapi.NewNullableString(&pubkey)),
+			).Execute()
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(resp.StatusCode).Should(Equal(201))
+			RegisterCheckPlot(client, ctx, "Notch", "069a79f4-44e9-4726-a5be-fca90e38aaf5", 2002)
+		})
+		It("will not flag an unknown instance", Requirement("PLOT-COMPROMISED-001"), func() {
+			resp := reportCompromised(stranger, stranger)
+			Expect(resp).Should(BeProblem(ProblemUnknownInstance, 404))
+		})
+		It("will not flag a key without its signature", Requirement("PLOT-COMPROMISED-002"), func() {
+			resp := reportCompromised(compromised, stranger)
+			signed := CompromisedBytes(compromised.Public().(ed25519.PublicKey))
+			Expect(resp).Should(BeProblem(ProblemChallengeFailed, 400).
+				WithExtension("challenge_bytes", base64.RawStdEncoding.EncodeToString(signed)))
+		})
+		It("will flag a compromised key once", Requirement("PLOT-COMPROMISED-003"), func() {
+			resp := reportCompromised(compromised, compromised)
+			Expect(resp.StatusCode).Should(Equal(204))
+			resp = reportCompromised(compromised, compromised)
+			Expect(resp).Should(BeProblem(ProblemNoEffectUpdate, 409))
+		})
+		It("will not register a plot on a compromised instance", Requirement("PLOT-COMPROMISED-004"), func() {
+			ctx := AddPlotAuth(ctx, "Notch", 2003)
+			resp, err := client.PlotAPI.RegisterPlot(ctx).UpdateInstanceRequest(
+				*openapi.NewUpdateInstanceRequest(*openapi.NewNullableString(&pubkey)),
+			).Execute()
+			Expect(err).Should(HaveOccurred())
+			Expect(resp).Should(BeProblem(ProblemInstanceKeyCompromised, 409).WithExtension("public_key", pubkey))
+
+			_, resp, err = client.PlotAPI.GetPlotInfo(ctx).Execute()
+			Expect(err).Should(HaveOccurred())
+			Expect(resp).Should(BeProblem(ProblemExpectedRoleAny, 403).
+				WithExtension("received", "unregistered"))
+		})
+		It("will not move a plot to a compromised instance", Requirement("PLOT-COMPROMISED-005"), func() {
+			ctx := AddPlotAuth(ctx, "Notch", 2002)
+			resp, err := client.PlotAPI.UpdateInstance(ctx).UpdateInstanceRequest(
+				*openapi.NewUpdateInstanceRequest(*openapi.NewNullableString(&pubkey)),
+			).Execute()
+			Expect(err).Should(HaveOccurred())
+			Expect(resp).Should(BeProblem(ProblemInstanceKeyCompromised, 409).WithExtension("public_key", pubkey))
+
+			plot, _, err := client.PlotAPI.GetPlotInfo(ctx).Execute()
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(plot.PublicKey.Get()).Should(BeNil())
+		})
+		It("will let a plot leave a compromised instance", Requirement("PLOT-COMPROMISED-006"), func() {
+			ctx := AddPlotAuth(ctx, "Notch", 2001)
+			plot, _, err := client.PlotAPI.GetPlotInfo(ctx).Execute()
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(plot.PublicKey.Get()).Should(HaveValue(Equal(pubkey)))
+
+			resp, err := client.PlotAPI.UpdateInstance(ctx).UpdateInstanceRequest(
+				*openapi.NewUpdateInstanceRequest(*openapi.NewNullableString(nil)),
+			).Execute()
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(resp.StatusCode).Should(Equal(200))
+
+			plot, _, err = client.PlotAPI.GetPlotInfo(ctx).Execute()
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(plot.PublicKey.Get()).Should(BeNil())
+			Expect(plot.Address.Get()).Should(BeNil())
+		})
+	})
+	Describe("Client refuses to use auth", func() {
+		It("will not be authorized when getting own plot info", Requirement("PLOT-AUTH-001"), func() {
+			plot, resp, err := client.PlotAPI.GetPlotInfo(ctx).Execute()
+			Expect(err).Should(HaveOccurred())
+			Expect(plot).Should(BeNil())
+			Expect(resp).Should(BeProblem(ProblemUnauthorized, 401))
+		})
+		It("will not be authorized when registering a plot", Requirement("PLOT-AUTH-002"), func() {
+			resp, err := client.PlotAPI.RegisterPlot(ctx).UpdateInstanceRequest(
+				*openapi.NewUpdateInstanceRequest(*openapi.NewNullableString(nil)),
+			).Execute()
+			Expect(err).Should(HaveOccurred())
+			Expect(resp).Should(BeProblem(ProblemUnauthorized, 401))
+		})
+		It("will not be authorized when updating a plot", Requirement("PLOT-AUTH-003"), func() {
+			resp, err := client.PlotAPI.UpdateInstance(ctx).UpdateInstanceRequest(
+				*openapi.NewUpdateInstanceRequest(*openapi.NewNullableString(nil)),
+			).Execute()
+			Expect(err).Should(HaveOccurred())
+			Expect(resp).Should(BeProblem(ProblemUnauthorized, 401))
+		})
+		It("will not be authorized when deleting a plot", Requirement("PLOT-AUTH-004"), Label(ProposalLabel), func() {
+			resp, err := target.Do(ctx, http.MethodDelete, "/v0/plot", nil)
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(resp).Should(BeProblem(ProblemUnauthorized, 401))
+		})
+	})
+})
+
+func RegisterCheckPlot(client *openapi.APIClient, ctx1 context.Context, username string, uuid string, plotId int32) {
+	ctx := AddPlotAuth(ctx1, username, plotId)
+	resp, err := client.PlotAPI.RegisterPlot(ctx).UpdateInstanceRequest(
+		*openapi.NewUpdateInstanceRequest(*openapi.NewNullableString(nil)),
+	).Execute()
+	Expect(err).ShouldNot(HaveOccurred())
+	Expect(resp.StatusCode).Should(Equal(201))
+
+	plot, resp, err := client.PlotAPI.GetPlotInfo(ctx).Execute()
+	Expect(err).ShouldNot(HaveOccurred())
+	Expect(resp.StatusCode).Should(Equal(200))
+	Expect(*plot).Should(Equal(openapi.Plot{
+		PlotId:       plotId,
+		Owner:        uuid,
+		PublicKey:    *openapi.NewNullableString(nil),
+		Address:      *openapi.NewNullableString(nil),
+		MailboxMsgId: 0,
+	}))
+}
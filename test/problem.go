@@ -0,0 +1,151 @@
+package tests
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/format"
+	"github.com/onsi/gomega/types"
+)
+
+const problemContentType = "application/problem+json; charset=utf-8"
+
+// ProblemMatcher checks that an *http.Response is an RFC 9457 problem details response of a registered type
+type ProblemMatcher struct {
+	Problem    *ProblemType
+	Status     int
+	extensions map[string]types.GomegaMatcher
+
+	mismatches []string
+}
+
+// BeProblem succeeds when the response has the problem content type and a body that satisfies the registered
+// schema of the problem type, with a status that agrees with the HTTP status of the response.
+//
+//	Expect(resp).Should(BeProblem(ProblemUnknownInstance, 404).WithExtension("public_key", key))
+func BeProblem(problem *ProblemType, status int) *ProblemMatcher {
+	return &ProblemMatcher{
+		Problem:    problem,
+		Status:     status,
+		extensions: map[string]types.GomegaMatcher{},
+	}
+}
+
+// WithExtension pins the value of an extension member beyond what the schema requires.
+// Plain values are compared with BeEquivalentTo, so 409 matches the decoded 409.0.
+func (m *ProblemMatcher) WithExtension(key string, value any) *ProblemMatcher {
+	matcher, ok := value.(types.GomegaMatcher)
+	if !ok {
+		matcher = BeEquivalentTo(value)
+	}
+	m.extensions[key] = matcher
+	return m
+}
+
+// ReadProblem decodes the problem body of a response, leaving the body readable for whoever comes next
+func ReadProblem(resp *http.Response) (map[string]any, []byte, error) {
+	raw, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	resp.Body = io.NopCloser(bytes.NewReader(raw))
+	if err != nil {
+		return nil, raw, err
+	}
+	var data map[string]any
+	err = json.Unmarshal(raw, &data)
+	return data, raw, err
+}
+
+func (m *ProblemMatcher) Match(actual any) (bool, error) {
+	resp, ok := actual.(*http.Response)
+	if !ok || resp == nil {
+		return false, fmt.Errorf("BeProblem expects a non-nil *http.Response, got %s", format.Object(actual, 1))
+	}
+	if !m.Problem.allows(m.Status) {
+		return false, fmt.Errorf("%s is never sent with status %d, registered statuses are %v", m.Problem.Type, m.Status, m.Problem.Statuses)
+	}
+	markExercised(m.Problem)
+	m.mismatches = nil
+	mismatch := func(f string, args ...any) {
+		m.mismatches = append(m.mismatches, fmt.Sprintf(f, args...))
+	}
+
+	if resp.StatusCode != m.Status {
+		mismatch("HTTP status is %d, expected %d", resp.StatusCode, m.Status)
+	}
+	if contentType := resp.Header.Get("Content-Type"); contentType != problemContentType {
+		mismatch("Content-Type is %q, expected %q", contentType, problemContentType)
+	}
+	data, raw, err := ReadProblem(resp)
+	if err != nil {
+		mismatch("body is not a JSON object (%v):\n%s", err, truncate(raw))
+		return false, nil
+	}
+
+	if data["type"] != m.Problem.Type {
+		mismatch("type is %s, expected %q", format.Object(data["type"], 0), m.Problem.Type)
+		mismatch("full body:\n%s", truncate(raw))
+		return false, nil
+	}
+	status, ok := data["status"].(float64)
+	switch {
+	case !ok:
+		mismatch("status is %s, expected the number %d", format.Object(data["status"], 0), m.Status)
+	case int(status) != resp.StatusCode:
+		mismatch("status in the body is %v but the HTTP status is %d", status, resp.StatusCode)
+	case int(status) != m.Status:
+		mismatch("status is %v, expected %d", status, m.Status)
+	}
+	errs, err := m.Problem.Validate(data)
+	if err != nil {
+		return false, err
+	}
+	for _, e := range errs {
+		mismatch("does not match the registered schema: %s", e)
+	}
+
+	keys := make([]string, 0, len(m.extensions))
+	for key := range m.extensions {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		value, present := data[key]
+		if !present {
+			mismatch("extension member %q is missing", key)
+			continue
+		}
+		ok, err := m.extensions[key].Match(value)
+		if err != nil {
+			return false, fmt.Errorf("extension member %q: %w", key, err)
+		}
+		if !ok {
+			mismatch("extension member %q: %s", key, m.extensions[key].FailureMessage(value))
+		}
+	}
+	if len(m.mismatches) > 0 {
+		mismatch("full body:\n%s", truncate(raw))
+	}
+	return len(m.mismatches) == 0, nil
+}
+
+func truncate(raw []byte) string {
+	const limit = 2048
+	if len(raw) > limit {
+		return string(raw[:limit]) + "..."
+	}
+	return string(raw)
+}
+
+func (m *ProblemMatcher) FailureMessage(actual any) string {
+	return fmt.Sprintf("Expected a %s problem with status %d, but\n\t%s", m.Problem.Type, m.Status, strings.Join(m.mismatches, "\n\t"))
+}
+
+func (m *ProblemMatcher) NegatedFailureMessage(actual any) string {
+	return fmt.Sprintf("Expected the response not to be a %s problem with status %d", m.Problem.Type, m.Status)
+}
@@ -0,0 +1,80 @@
+package tests
+
+import (
+	"io"
+	"net/http"
+	"strings"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Matching problems", Label("unit"), func() {
+	response := func(status int, contentType string, body string) *http.Response {
+		return &http.Response{
+			StatusCode: status,
+			Header:     http.Header{"Content-Type": {contentType}},
+			Body:       io.NopCloser(strings.NewReader(body)),
+		}
+	}
+	const unknownInstance = `{
+		"type": "/v0/problems/unknown-instance",
+		"title": "Specified instance has not been identified",
+		"status": 404,
+		"public_key": "key"
+	}`
+
+	It("should match a problem of the type", func() {
+		matcher := BeProblem(ProblemUnknownInstance, 404).WithExtension("public_key", "key")
+		Expect(matcher.Match(response(404, problemContentType, unknownInstance))).Should(BeTrue())
+	})
+	DescribeTable("should say what doesn't match",
+		func(status int, contentType string, body string, mismatch string) {
+			matcher := BeProblem(ProblemUnknownInstance, 404).WithExtension("public_key", "key")
+			resp := response(status, contentType, body)
+			Expect(matcher.Match(resp)).Should(BeFalse())
+			Expect(matcher.FailureMessage(resp)).Should(ContainSubstring(mismatch))
+		},
+		Entry("another HTTP status",
+			409, problemContentType, strings.Replace(unknownInstance, "404", "409", 1),
+			"HTTP status is 409, expected 404"),
+		Entry("a status in the body other than the HTTP one",
+			404, problemContentType, strings.Replace(unknownInstance, "404", "409", 1),
+			"status in the body is 409 but the HTTP status is 404"),
+		Entry("a status that isn't a number",
+			404, problemContentType, strings.Replace(unknownInstance, "404", `"404"`, 1),
+			`status is <string>: "404", expected the number 404`),
+		Entry("plain JSON",
+			404, "application/json", unknownInstance,
+			`Content-Type is "application/json", expected "application/problem+json; charset=utf-8"`),
+		Entry("a body that isn't JSON",
+			404, problemContentType, "not found",
+			"body is not a JSON object"),
+		Entry("another type",
+			404, problemContentType, strings.Replace(unknownInstance, "unknown-instance", "unknown-plot", 1),
+			`type is <string>: "/v0/problems/unknown-plot", expected "/v0/problems/unknown-instance"`),
+		Entry("another title",
+			404, problemContentType, strings.Replace(unknownInstance, "has not", "hasn't", 1),
+			"does not match the registered schema: title"),
+		Entry("a missing extension",
+			404, problemContentType, strings.Replace(unknownInstance, `"public_key"`, `"key"`, 1),
+			`extension member "public_key" is missing`),
+		Entry("an extension of the wrong type",
+			404, problemContentType, strings.Replace(unknownInstance, `"key"`, "7", 1),
+			"does not match the registered schema: public_key"),
+		Entry("another extension value",
+			404, problemContentType, strings.Replace(unknownInstance, `"key"`, `"other"`, 1),
+			`extension member "public_key"`),
+	)
+	It("should refuse a status the type is never sent with", func() {
+		_, err := BeProblem(ProblemUnknownInstance, 400).Match(response(400, problemContentType, unknownInstance))
+		Expect(err).Should(MatchError(ContainSubstring("never sent with status 400")))
+	})
+	It("should leave the body readable", func() {
+		resp := response(404, problemContentType, unknownInstance)
+		Expect(resp).Should(BeProblem(ProblemUnknownInstance, 404))
+		body, err := io.ReadAll(resp.Body)
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(body).Should(MatchJSON(unknownInstance))
+	})
+})
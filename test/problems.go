@@ -0,0 +1,290 @@
+package tests
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	. "github.com/onsi/ginkgo/v2"
+	"github.com/onsi/ginkgo/v2/types"
+	"github.com/xeipuuv/gojsonschema"
+)
+
+// ProblemType is a problem type the protocol defines, along with everything a response of that type must contain
+type ProblemType struct {
+	Type  string
+	Title string
+	// Every status the type may be sent with, which one depends on the endpoint
+	Statuses []int
+	// JSON schema the extension members must satisfy, on top of the standard members
+	Extensions string
+
+	schema *gojsonschema.Schema
+}
+
+var problemRegistry = map[string]*ProblemType{}
+
+func registerProblem(p ProblemType) *ProblemType {
+	if _, ok := problemRegistry[p.Type]; ok {
+		panic(fmt.Sprintf("problem type %s is registered twice", p.Type))
+	}
+	schemas := []any{map[string]any{
+		"type":     "object",
+		"required": []string{"type", "title", "status"},
+		"properties": map[string]any{
+			"type":   map[string]any{"const": p.Type},
+			"title":  map[string]any{"const": p.Title},
+			"status": map[string]any{"enum": p.Statuses},
+		},
+	}}
+	if p.Extensions != "" {
+		var ext any
+		err := json.Unmarshal([]byte(p.Extensions), &ext)
+		if err != nil {
+			panic(fmt.Sprintf("extension schema of %s is invalid JSON: %v", p.Type, err))
+		}
+		schemas = append(schemas, ext)
+	}
+	schema, err := gojsonschema.NewSchema(gojsonschema.NewGoLoader(map[string]any{"allOf": schemas}))
+	if err != nil {
+		panic(fmt.Sprintf("schema of %s is invalid: %v", p.Type, err))
+	}
+	p.schema = schema
+	problemRegistry[p.Type] = &p
+	return &p
+}
+
+// Problems lists every registered problem type, sorted by type
+func Problems() []*ProblemType {
+	out := make([]*ProblemType, 0, len(problemRegistry))
+	for _, p := range problemRegistry {
+		out = append(out, p)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Type < out[j].Type })
+	return out
+}
+
+// Validate checks a decoded problem body against the registered title, statuses and extension schema
+func (p *ProblemType) Validate(body map[string]any) ([]string, error) {
+	result, err := p.schema.Validate(gojsonschema.NewGoLoader(body))
+	if err != nil {
+		return nil, err
+	}
+	errs := []string{}
+	for _, e := range result.Errors() {
+		errs = append(errs, e.String())
+	}
+	return errs, nil
+}
+
+func (p *ProblemType) allows(status int) bool {
+	for _, s := range p.Statuses {
+		if s == status {
+			return true
+		}
+	}
+	return false
+}
+
+var (
+	ProblemBadRequest = registerProblem(ProblemType{
+		Type:     "https://tools.ietf.org/html/rfc9110#section-15.5.1",
+		Title:    "Bad Request",
+		Statuses: []int{400},
+		Extensions: `{
+			"properties": {"parameter": {"type": "string"}}
+		}`,
+	})
+	ProblemUnauthorized = registerProblem(ProblemType{
+		Type:     "https://tools.ietf.org/html/rfc9110#section-15.5.2",
+		Title:    "Unauthorized",
+		Statuses: []int{401},
+	})
+	ProblemNotFound = registerProblem(ProblemType{
+		Type:     "https://tools.ietf.org/html/rfc9110#section-15.5.5",
+		Title:    "Not Found",
+		Statuses: []int{404},
+	})
+	ProblemAlreadyExists = registerProblem(ProblemType{
+		Type:     "/v0/problems/already-exists",
+		Title:    "The resource being created already exists",
+		Statuses: []int{409},
+	})
+	ProblemNoEffectUpdate = registerProblem(ProblemType{
+		Type:     "/v0/problems/no-effect-update",
+		Title:    "The update had no effect",
+		Statuses: []int{409},
+	})
+	ProblemUnknownInstance = registerProblem(ProblemType{
+		Type:     "/v0/problems/unknown-instance",
+		Title:    "Specified instance has not been identified",
+		Statuses: []int{404, 409},
+		Extensions: `{
+			"required": ["public_key"],
+			"properties": {"public_key": {"type": "string"}}
+		}`,
+	})
+	ProblemChallengeFailed = registerProblem(ProblemType{
+		Type:     "/v0/problems/challenge-failed",
+		Title:    "Invalid challenge signature",
+		Statuses: []int{400},
+		Extensions: `{
+			"required": ["challenge_bytes"],
+			"properties": {"challenge_bytes": {"type": "string", "pattern": "^[A-Za-z0-9+/]*$"}}
+		}`,
+	})
+	ProblemInstanceUnreachable = registerProblem(ProblemType{
+		Type:     "/v0/problems/federation/instance-unreachable",
+		Title:    "Cannot reach the introduced instance",
+		Statuses: []int{400},
+		Extensions: `{
+			"required": ["address"],
+			"properties": {"address": {"type": "string"}}
+		}`,
+	})
+	ProblemNonCompliance = registerProblem(ProblemType{
+		Type:     "/v0/problems/federation/non-compliance",
+		Title:    "The introduced instance is not compliant",
+		Statuses: []int{400},
+		Extensions: `{
+			"required": ["address"],
+			"properties": {"address": {"type": "string"}}
+		}`,
+	})
+	ProblemMismatchedAddress = registerProblem(ProblemType{
+		Type:     "/v0/problems/instance-introduction/mismatched-address",
+		Title:    "The introduced instance reported a different address",
+		Statuses: []int{400},
+		Extensions: `{
+			"required": ["expected", "received"],
+			"properties": {"expected": {"type": "string"}, "received": {"type": "string"}}
+		}`,
+	})
+	ProblemMismatchedPublicKey = registerProblem(ProblemType{
+		Type:     "/v0/problems/instance-introduction/mismatched-public-key",
+		Title:    "The introduced instance reported a different public key",
+		Statuses: []int{400},
+		Extensions: `{
+			"required": ["expected", "received"],
+			"properties": {"expected": {"type": "string"}, "received": {"type": "string"}}
+		}`,
+	})
+	ProblemInstanceKeyCompromised = registerProblem(ProblemType{
+		Type:     "/v0/problems/instance-key-compromised",
+		Title:    "The instance key has been compromised",
+		Statuses: []int{409},
+		Extensions: `{
+			"required": ["public_key"],
+			"properties": {"public_key": {"type": "string"}}
+		}`,
+	})
+	ProblemExpectedRoleAny = registerProblem(ProblemType{
+		Type:     "/v0/problems/expected-role/any",
+		Title:    "Expected any registration",
+		Statuses: []int{403},
+		Extensions: `{
+			"required": ["expected", "received"],
+			"properties": {
+				"expected": {"type": "array", "items": {"type": "string"}},
+				"received": {"type": "string"}
+			}
+		}`,
+	})
+)
+
+// Problem types only proposals assert on, the published API doesn't define them, see ProposalLabel
+var (
+	ProblemInvalidSignature = registerProblem(ProblemType{
+		Type:     "/v0/problems/federation/invalid-signature",
+		Title:    "The request signature is invalid",
+		Statuses: []int{401},
+		Extensions: `{
+			"required": ["signed_bytes"],
+			"properties": {"signed_bytes": {"type": "string", "pattern": "^[A-Za-z0-9+/]*$"}}
+		}`,
+	})
+	ProblemExpiredRequest = registerProblem(ProblemType{
+		Type:     "/v0/problems/federation/expired-request",
+		Title:    "The request timestamp is outside the accepted window",
+		Statuses: []int{401},
+		Extensions: `{
+			"required": ["timestamp"],
+			"properties": {"timestamp": {"type": "integer"}}
+		}`,
+	})
+	ProblemReplayedNonce = registerProblem(ProblemType{
+		Type:     "/v0/problems/federation/replayed-nonce",
+		Title:    "The request nonce was already used",
+		Statuses: []int{409},
+		Extensions: `{
+			"required": ["nonce"],
+			"properties": {"nonce": {"type": "string"}}
+		}`,
+	})
+	ProblemUnknownPlot = registerProblem(ProblemType{
+		Type:     "/v0/problems/unknown-plot",
+		Title:    "Specified plot is not registered",
+		Statuses: []int{404},
+		Extensions: `{
+			"required": ["plot_id"],
+			"properties": {"plot_id": {"type": "integer"}}
+		}`,
+	})
+	ProblemPlotForbidden = registerProblem(ProblemType{
+		Type:     "/v0/problems/plot/forbidden",
+		Title:    "Only the owner of a plot can delete it",
+		Statuses: []int{403},
+		Extensions: `{
+			"required": ["plot_id"],
+			"properties": {"plot_id": {"type": "integer"}}
+		}`,
+	})
+	ProblemMailboxForbidden = registerProblem(ProblemType{
+		Type:     "/v0/problems/mailbox/forbidden",
+		Title:    "Cannot access the mailbox of another plot",
+		Statuses: []int{403},
+		Extensions: `{
+			"required": ["plot_id"],
+			"properties": {"plot_id": {"type": "integer"}}
+		}`,
+	})
+)
+
+const problemEntry = "problem type"
+
+// markExercised records on the running spec that it asserted on a problem type
+func markExercised(p *ProblemType) {
+	AddReportEntry(problemEntry, p.Type, ReportEntryVisibilityNever)
+}
+
+// UnexercisedProblems lists the registered problem types that no requirement in the report asserted on
+func UnexercisedProblems(report types.Report) []string {
+	exercised := map[string]bool{}
+	for _, spec := range report.SpecReports {
+		// Specs of the suite itself build responses of every type, they say nothing about the instance
+		if RequirementOf(spec.Labels()) == "" {
+			continue
+		}
+		for _, entry := range spec.ReportEntries {
+			if entry.Name == problemEntry {
+				exercised[entry.StringRepresentation()] = true
+			}
+		}
+	}
+	out := []string{}
+	for _, p := range Problems() {
+		if !exercised[p.Type] {
+			out = append(out, p.Type)
+		}
+	}
+	return out
+}
+
+var _ = ReportAfterSuite("problem type coverage", func(report Report) {
+	if report.SuiteConfig.DryRun {
+		return
+	}
+	for _, p := range UnexercisedProblems(report) {
+		GinkgoWriter.Printf("No spec exercised problem type %s\n", p)
+	}
+})
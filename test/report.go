@@ -0,0 +1,262 @@
+package tests
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	"github.com/onsi/ginkgo/v2/reporters"
+	"github.com/onsi/ginkgo/v2/types"
+	. "github.com/onsi/gomega"
+)
+
+const requirementPrefix = "req:"
+
+// ProposalLabel marks specs of behaviour the published API doesn't define yet, e.g. endpoints missing from the client.
+// They run and are reported like any other, but never count towards the compliance level.
+const ProposalLabel = "proposal"
+
+// ComplianceSchemaVersion is bumped whenever a field of ComplianceReport changes meaning or goes away
+const ComplianceSchemaVersion = 2
+
+// Requirement tags a spec with the stable id it is listed under in the compliance report.
+// Ids never get reused, a removed spec retires its id.
+func Requirement(id string) Labels {
+	return Label(requirementPrefix + id)
+}
+
+// RequirementOf returns the requirement id among a spec's labels, or "" if it has none
+func RequirementOf(labels []string) string {
+	for _, label := range labels {
+		if strings.HasPrefix(label, requirementPrefix) {
+			return strings.TrimPrefix(label, requirementPrefix)
+		}
+	}
+	return ""
+}
+
+type ComplianceLevel string
+
+const (
+	// Every requirement passed
+	ComplianceFull ComplianceLevel = "full"
+	// Nothing failed but some requirements were skipped, e.g. by a label filter
+	ComplianceIncomplete ComplianceLevel = "incomplete"
+	// Some requirements failed
+	CompliancePartial ComplianceLevel = "partial"
+	// No requirement passed
+	ComplianceNone ComplianceLevel = "none"
+)
+
+type RequirementStatus string
+
+const (
+	RequirementPassed  RequirementStatus = "passed"
+	RequirementFailed  RequirementStatus = "failed"
+	RequirementSkipped RequirementStatus = "skipped"
+)
+
+type RequirementResult struct {
+	Id       string            `json:"id"`
+	Spec     string            `json:"spec"`
+	Status   RequirementStatus `json:"status"`
+	Location string            `json:"location"`
+	Duration float64           `json:"duration_seconds"`
+	Failure  string            `json:"failure,omitempty"`
+	// Logs and state saved when the requirement failed, absolute paths
+	Artifacts []string `json:"artifacts,omitempty"`
+}
+
+type ComplianceReport struct {
+	SchemaVersion int                 `json:"schema_version"`
+	Suite         string              `json:"suite"`
+	Target        string              `json:"target"`
+	StartTime     time.Time           `json:"start_time"`
+	EndTime       time.Time           `json:"end_time"`
+	Level         ComplianceLevel     `json:"level"`
+	Passed        int                 `json:"passed"`
+	Failed        int                 `json:"failed"`
+	Skipped       int                 `json:"skipped"`
+	Requirements  []RequirementResult `json:"requirements"`
+	// Requirements labelled ProposalLabel, left out of the counts and the level
+	Proposals []RequirementResult `json:"proposals"`
+	// Registered problem types that no spec asserted on during this run
+	UnexercisedProblems []string `json:"unexercised_problem_types"`
+	// Run again with this seed to get the same keys
+	KeySeed string         `json:"key_seed"`
+	Keys    []AllocatedKey `json:"keys"`
+}
+
+func requirementStatus(state types.SpecState) RequirementStatus {
+	switch {
+	case state.Is(types.SpecStatePassed):
+		return RequirementPassed
+	case state.Is(types.SpecStateSkipped | types.SpecStatePending):
+		return RequirementSkipped
+	default:
+		return RequirementFailed
+	}
+}
+
+// BuildComplianceReport keeps the specs that carry a requirement id and grades the run on those that aren't proposals
+func BuildComplianceReport(report types.Report, target string, keySeed string, artifactsDir string) ComplianceReport {
+	out := ComplianceReport{
+		SchemaVersion:       ComplianceSchemaVersion,
+		Suite:               report.SuiteDescription,
+		Target:              target,
+		StartTime:           report.StartTime,
+		EndTime:             report.EndTime,
+		Requirements:        []RequirementResult{},
+		Proposals:           []RequirementResult{},
+		UnexercisedProblems: UnexercisedProblems(report),
+		KeySeed:             keySeed,
+		Keys:                AllocatedKeys(report),
+	}
+	for _, spec := range report.SpecReports {
+		id := RequirementOf(spec.Labels())
+		if spec.LeafNodeType != types.NodeTypeIt || id == "" {
+			continue
+		}
+		result := RequirementResult{
+			Id:       id,
+			Spec:     spec.FullText(),
+			Status:   requirementStatus(spec.State),
+			Location: spec.LeafNodeLocation.String(),
+			Duration: spec.RunTime.Seconds(),
+		}
+		if result.Status == RequirementFailed {
+			result.Failure = spec.Failure.Message
+			if artifactsDir != "" {
+				result.Artifacts = ArtifactsOf(artifactsDir, spec)
+			}
+		}
+		if slices.Contains(spec.Labels(), ProposalLabel) {
+			out.Proposals = append(out.Proposals, result)
+			continue
+		}
+		switch result.Status {
+		case RequirementPassed:
+			out.Passed++
+		case RequirementFailed:
+			out.Failed++
+		case RequirementSkipped:
+			out.Skipped++
+		}
+		out.Requirements = append(out.Requirements, result)
+	}
+	for _, results := range [][]RequirementResult{out.Requirements, out.Proposals} {
+		sort.Slice(results, func(i, j int) bool {
+			return results[i].Id < results[j].Id
+		})
+	}
+
+	out.Level = Grade(out.Passed, out.Failed, out.Skipped)
+	return out
+}
+
+// Grade is the compliance level of a run with these requirement counts
+func Grade(passed int, failed int, skipped int) ComplianceLevel {
+	switch {
+	case passed == 0:
+		return ComplianceNone
+	case failed > 0:
+		return CompliancePartial
+	case skipped > 0:
+		return ComplianceIncomplete
+	default:
+		return ComplianceFull
+	}
+}
+
+func (r ComplianceReport) WriteJSON(path string) error {
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0o644)
+}
+
+// WriteJUnit writes one test case per requirement, named after its id so CI can track it across runs.
+// Proposals get a suite of their own, so they never show up in the numbers of the graded one.
+func (r ComplianceReport) WriteJUnit(path string) error {
+	suite := r.junitSuite(r.Suite, r.Requirements)
+	suite.Properties = reporters.JUnitProperties{Properties: []reporters.JUnitProperty{
+		{Name: "SchemaVersion", Value: fmt.Sprint(r.SchemaVersion)},
+		{Name: "ComplianceLevel", Value: string(r.Level)},
+		{Name: "KeySeed", Value: r.KeySeed},
+	}}
+	suites := reporters.JUnitTestSuites{
+		Tests:      suite.Tests,
+		Failures:   suite.Failures,
+		Time:       suite.Time,
+		TestSuites: []reporters.JUnitTestSuite{suite},
+	}
+	if len(r.Proposals) > 0 {
+		proposals := r.junitSuite(r.Suite+" ("+ProposalLabel+")", r.Proposals)
+		suites.Tests += proposals.Tests
+		suites.Failures += proposals.Failures
+		suites.TestSuites = append(suites.TestSuites, proposals)
+	}
+
+	data, err := xml.MarshalIndent(suites, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append([]byte(xml.Header), data...), 0o644)
+}
+
+func (r ComplianceReport) junitSuite(name string, results []RequirementResult) reporters.JUnitTestSuite {
+	suite := reporters.JUnitTestSuite{
+		Name:      name,
+		Package:   r.Target,
+		Tests:     len(results),
+		Time:      r.EndTime.Sub(r.StartTime).Seconds(),
+		Timestamp: r.StartTime.Format("2006-01-02T15:04:05"),
+	}
+	for _, req := range results {
+		test := reporters.JUnitTestCase{
+			Name:      req.Id,
+			Classname: req.Spec,
+			Status:    string(req.Status),
+			Time:      req.Duration,
+		}
+		switch req.Status {
+		case RequirementFailed:
+			suite.Failures++
+			test.Failure = &reporters.JUnitFailure{Message: req.Failure, Type: "failed", Description: req.Location}
+			// The attachment convention of the Jenkins JUnit plugin, other tools show them as plain paths
+			for _, path := range req.Artifacts {
+				test.SystemOut += fmt.Sprintf("[[ATTACHMENT|%s]]\n", path)
+			}
+		case RequirementSkipped:
+			suite.Skipped++
+			test.Skipped = &reporters.JUnitSkipped{Message: "skipped"}
+		}
+		suite.TestCases = append(suite.TestCases, test)
+	}
+	return suite
+}
+
+var _ = ReportAfterSuite("compliance report", func(report Report) {
+	env := CurrentEnv()
+	if report.SuiteConfig.DryRun || env.JSONReport == "" && env.JUnitReport == "" {
+		return
+	}
+	target := env.TargetURL
+	if target == "" {
+		target = env.ComposePath
+	}
+	compliance := BuildComplianceReport(report, target, env.KeySeed, env.ArtifactsDir)
+	if env.JSONReport != "" {
+		Expect(compliance.WriteJSON(env.JSONReport)).Should(Succeed())
+	}
+	if env.JUnitReport != "" {
+		Expect(compliance.WriteJUnit(env.JUnitReport)).Should(Succeed())
+	}
+})
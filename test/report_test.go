@@ -0,0 +1,56 @@
+package tests
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	"github.com/onsi/ginkgo/v2/types"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Grading a run", Label("unit"), func() {
+	DescribeTable("Grade",
+		func(passed, failed, skipped int, level ComplianceLevel) {
+			Expect(Grade(passed, failed, skipped)).Should(Equal(level))
+		},
+		Entry("every requirement passed", 10, 0, 0, ComplianceFull),
+		Entry("some requirements were skipped", 8, 0, 2, ComplianceIncomplete),
+		Entry("some requirements failed", 8, 2, 0, CompliancePartial),
+		Entry("requirements failed and were skipped", 6, 2, 2, CompliancePartial),
+		Entry("nothing passed", 0, 0, 10, ComplianceNone),
+		Entry("everything failed", 0, 10, 0, ComplianceNone),
+		Entry("nothing ran", 0, 0, 0, ComplianceNone),
+	)
+
+	spec := func(state types.SpecState, labels ...string) types.SpecReport {
+		return types.SpecReport{
+			LeafNodeType:   types.NodeTypeIt,
+			LeafNodeText:   "spec",
+			LeafNodeLabels: labels,
+			State:          state,
+			Failure:        types.Failure{Message: "failed"},
+		}
+	}
+	It("should grade on the requirements that aren't proposals", func() {
+		compliance := BuildComplianceReport(types.Report{SpecReports: types.SpecReports{
+			spec(types.SpecStatePassed, "req:B-001"),
+			spec(types.SpecStatePassed, "req:A-001"),
+			spec(types.SpecStateSkipped, "req:A-002"),
+			spec(types.SpecStateFailed, "req:P-001", ProposalLabel),
+			spec(types.SpecStatePassed, "req:P-002", ProposalLabel),
+			spec(types.SpecStateFailed),
+		}}, "target", DefaultKeySeed, "")
+
+		Expect(compliance.Passed).Should(Equal(2))
+		Expect(compliance.Failed).Should(Equal(0))
+		Expect(compliance.Skipped).Should(Equal(1))
+		Expect(compliance.Level).Should(Equal(ComplianceIncomplete))
+		Expect(compliance.Requirements).Should(HaveExactElements(
+			HaveField("Id", "A-001"),
+			HaveField("Id", "A-002"),
+			HaveField("Id", "B-001"),
+		))
+		Expect(compliance.Proposals).Should(HaveExactElements(
+			SatisfyAll(HaveField("Id", "P-001"), HaveField("Status", RequirementFailed), HaveField("Failure", "failed")),
+			SatisfyAll(HaveField("Id", "P-002"), HaveField("Status", RequirementPassed)),
+		))
+	})
+})
@@ -0,0 +1,107 @@
+package tests
+
+import (
+	"context"
+	"crypto/ed25519"
+	"net/http"
+
+	openapi "github.com/DFMailbox/go-client"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/types"
+)
+
+// An instance rotating its key introduces itself again with the new key and update set.
+// It keeps its address and its plots, the old key is gone for good.
+// A proposal, the published API doesn't say what update does with a new key at a known address.
+var _ = Describe("Rotating an instance key", Ordered, Label("federation", ProposalLabel), func() {
+	var client *openapi.APIClient
+	var ctx context.Context
+	var target *Target
+	// peer rotates from oldKey to newKey
+	var peer *MockPeer
+	var oldKey, newKey ed25519.PrivateKey
+	const plot = 7001
+	// rejected is how an instance turns down a key the address answers for with another one.
+	// Whether it checks the signature or the reported key first is up to it.
+	rejected := func(expected ed25519.PrivateKey, received ed25519.PrivateKey) types.GomegaMatcher {
+		return Or(
+			BeProblem(ProblemChallengeFailed, 400),
+			BeProblem(ProblemMismatchedPublicKey, 400).
+				WithExtension("expected", EncodedPublicKey(expected)).
+				WithExtension("received", EncodedPublicKey(received)),
+		)
+	}
+	rotate := func(to ed25519.PrivateKey) (*http.Response, error) {
+		yes := true
+		return client.InstanceAPI.IntroduceInstance(ctx).IntroduceInstanceRequest(
+			openapi.IntroduceInstanceRequest{
+				PublicKey: EncodedPublicKey(to),
+				Address:   peer.Address,
+				Update:    &yes,
+			},
+		).Execute()
+	}
+	BeforeAll(func() {
+		t, err := SetupDefault()
+		target = t
+		Expect(err).ShouldNot(HaveOccurred())
+
+		ctx = target.Context()
+		client = target.Client()
+		oldKey = TestKey("old")
+		newKey = TestKey("new")
+		peer = NewMockPeer(oldKey)
+		_, err = client.InstanceAPI.IntroduceInstance(ctx).IntroduceInstanceRequest(
+			*openapi.NewIntroduceInstanceRequest(peer.PublicKey(), peer.Address),
+		).Execute()
+		Expect(err).ShouldNot(HaveOccurred())
+
+		key := EncodedPublicKey(oldKey)
+		resp, err := client.PlotAPI.RegisterPlot(AddPlotAuth(ctx, "Notch", plot)).UpdateInstanceRequest(
+			*openapi.NewUpdateInstanceRequest(*openapi.NewNullableString(&key)),
+		).Execute()
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(resp.StatusCode).Should(Equal(201))
+	})
+
+	It("should reject a rotation the address can't prove", Requirement("FED-ROTATE-002"), func() {
+		before := peer.Len()
+		// The peer still answers with the old key
+		resp, err := rotate(newKey)
+		Expect(err).Should(HaveOccurred())
+		Expect(peer.Len()).Should(Equal(before+1), "the instance must challenge the address for the new key")
+		Expect(resp).Should(rejected(newKey, oldKey))
+		ExpectUnknownInstance(client, ctx, newKey)
+
+		oai, _, err := client.InstanceAPI.LookupInstanceAddress(ctx).PublicKey(EncodedPublicKey(oldKey)).Execute()
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(oai.LookupInstanceAddress200ResponseOneOf.Instance.Address.Get()).Should(HaveValue(Equal(peer.Address)))
+	})
+	It("should rotate once the address proves the new key", Requirement("FED-ROTATE-005"), func() {
+		peer.Rotate(newKey)
+		resp, err := rotate(newKey)
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(resp.StatusCode).Should(Equal(200))
+	})
+	It("should look the instance up by its new key", Requirement("FED-ROTATE-006"), func() {
+		oai, _, err := client.InstanceAPI.LookupInstanceAddress(ctx).PublicKey(peer.PublicKey()).Execute()
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(oai.LookupInstanceAddress200ResponseOneOf.Instance.Address.Get()).Should(HaveValue(Equal(peer.Address)))
+	})
+	It("should forget the old key", Requirement("FED-ROTATE-007"), func() {
+		ExpectUnknownInstance(client, ctx, oldKey)
+	})
+	It("should move plots to the new key", Requirement("FED-ROTATE-008"), func() {
+		info, _, err := client.PlotAPI.GetPlotInfo(AddPlotAuth(ctx, "Notch", plot)).Execute()
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(info.PublicKey.Get()).Should(HaveValue(Equal(EncodedPublicKey(newKey))))
+		Expect(info.Address.Get()).Should(HaveValue(Equal(peer.Address)))
+	})
+	It("should not rotate back to the old key", Requirement("FED-ROTATE-009"), func() {
+		resp, err := rotate(oldKey)
+		Expect(err).Should(HaveOccurred())
+		Expect(resp).Should(rejected(oldKey, newKey))
+		ExpectUnknownInstance(client, ctx, oldKey)
+	})
+})
@@ -1,7 +1,6 @@
 package tests
 
 import (
-	"fmt"
 	"io"
 	"net/http"
 
@@ -11,17 +10,15 @@ import (
 )
 
 var _ = Describe("Running test stack", func() {
-	It("returns the service name", func() {
-		stack, natPort, err := SetupDefault()
+	It("returns the service name", Requirement("STACK-001"), func() {
+		target, err := SetupDefault()
 		Expect(err).Should(BeNil())
-		port := natPort.Port()
-		log.Printf("Address: localhost:%s", port)
-		res, err := http.Get(fmt.Sprintf("http://localhost:%s", port))
+		log.Printf("Address: %s", target.URL)
+		res, err := http.Get(target.URL)
 		Expect(err).Should(BeNil())
 		body, err := io.ReadAll(res.Body)
 		Expect(err).Should(BeNil())
 		defer res.Body.Close()
 		Expect(string(body)).Should(Equal("dfmailbox"))
-		defer Teardown(stack)
 	})
 })
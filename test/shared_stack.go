@@ -0,0 +1,201 @@
+package tests
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"maps"
+	"net/http"
+	"slices"
+	"sync"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	tcexec "github.com/testcontainers/testcontainers-go/exec"
+	"github.com/testcontainers/testcontainers-go/modules/compose"
+)
+
+// Labels a compose service declares to let every Describe block share one stack
+const (
+	// A path on the instance, declared on its service. The suite POSTs to it and expects a 2xx.
+	ResetEndpointLabel = "dfmc.reset.endpoint"
+	// A command run with sh -c in the container of the service declaring it, e.g. one truncating the database
+	ResetCommandLabel = "dfmc.reset.command"
+)
+
+// ResetHooks return a stack to the state it started in, commands run before the endpoint is called
+type ResetHooks struct {
+	Endpoint string `json:"endpoint,omitempty"`
+	// The command of every service declaring one, by service name
+	Commands map[string]string `json:"commands,omitempty"`
+}
+
+func (h ResetHooks) Declared() bool {
+	return h.Endpoint != "" || len(h.Commands) > 0
+}
+
+// sharedStacks is what process 1 hands every ginkgo process, Projects is empty when there is nothing to share
+type sharedStacks struct {
+	// The project and URL of every process, process n gets index n-1
+	Projects []string   `json:"projects"`
+	URLs     []string   `json:"urls"`
+	Hooks    ResetHooks `json:"hooks"`
+}
+
+// sharedTarget is the stack of this process, SetupDefault resets and returns it instead of starting one when set
+var sharedTarget *Target
+var sharedHooks ResetHooks
+
+// Process 1 starts a stack for every process at once, since stacks started in parallel processes can't be shared.
+// Its handles tear every stack down, ginkgo runs cleanup registered here on process 1 only once every process is done.
+var _ = SynchronizedBeforeSuite(func() []byte {
+	env := CurrentEnv()
+	if !env.SharedStack || env.TargetURL != "" {
+		return nil
+	}
+	suiteConfig, _ := GinkgoConfiguration()
+	stacks, err := startSharedStacks(env, suiteConfig.ParallelTotal)
+	Expect(err).ShouldNot(HaveOccurred())
+	data, err := json.Marshal(stacks)
+	Expect(err).ShouldNot(HaveOccurred())
+	return data
+}, func(data []byte) {
+	if len(data) == 0 {
+		return
+	}
+	var stacks sharedStacks
+	Expect(json.Unmarshal(data, &stacks)).Should(Succeed())
+	if len(stacks.Projects) == 0 {
+		return
+	}
+	i := GinkgoParallelProcess() - 1
+	// The compose handle of process 1 can't cross processes, a new one finds the containers by project.
+	// It is only tracked for artifacts and never torn down itself, process 1 removes the stack when the suite is over.
+	stack, err := compose.NewDockerComposeWith(
+		compose.StackIdentifier(stacks.Projects[i]),
+		compose.WithStackFiles(CurrentEnv().ComposePath),
+	)
+	Expect(err).ShouldNot(HaveOccurred())
+	sharedTarget = &Target{Stack: stack, Project: stacks.Projects[i], URL: stacks.URLs[i], Address: defaultAddress, env: CurrentEnv()}
+	if GinkgoParallelProcess() != 1 {
+		trackStack(sharedTarget)
+	}
+	sharedHooks = stacks.Hooks
+})
+
+// startSharedStacks starts one stack to read its reset hooks, and the rest only if it has any
+func startSharedStacks(env Environment, count int) (sharedStacks, error) {
+	vars, err := defaultStackEnv(env)
+	if err != nil {
+		return sharedStacks{}, err
+	}
+	first, err := newStack(projectName(1, "shared"), env)
+	if err != nil {
+		return sharedStacks{}, err
+	}
+	err = first.up(vars)
+	if err != nil {
+		return sharedStacks{}, err
+	}
+	hooks, err := readResetHooks(first)
+	if err != nil {
+		return sharedStacks{}, err
+	}
+	if !hooks.Declared() {
+		log.Printf("%s declares neither %s nor %s, every Describe starts its own stack", env.ComposePath, ResetEndpointLabel, ResetCommandLabel)
+		Teardown(first)
+		return sharedStacks{}, nil
+	}
+
+	targets := []*Target{first}
+	for process := 2; process <= count; process++ {
+		target, err := newStack(projectName(process, "shared"), env)
+		if err != nil {
+			return sharedStacks{}, err
+		}
+		targets = append(targets, target)
+	}
+	errs := make([]error, len(targets))
+	var wg sync.WaitGroup
+	for i, target := range targets[1:] {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i+1] = target.up(vars)
+		}()
+	}
+	wg.Wait()
+	err = errors.Join(errs...)
+	if err != nil {
+		return sharedStacks{}, err
+	}
+
+	stacks := sharedStacks{Hooks: hooks}
+	for _, target := range targets {
+		stacks.Projects = append(stacks.Projects, target.Project)
+		stacks.URLs = append(stacks.URLs, target.URL)
+	}
+	return stacks, nil
+}
+
+// readResetHooks collects the reset labels of every service in a running stack
+func readResetHooks(target *Target) (ResetHooks, error) {
+	ctx := context.Background()
+	hooks := ResetHooks{Commands: map[string]string{}}
+	for _, service := range target.Stack.Services() {
+		container, err := target.Stack.ServiceContainer(ctx, service)
+		if err != nil {
+			return hooks, fmt.Errorf("Failed to find the container of %s %v", service, err)
+		}
+		info, err := container.Inspect(ctx)
+		if err != nil {
+			return hooks, fmt.Errorf("Failed to inspect %s %v", service, err)
+		}
+		labels := info.Config.Labels
+		if endpoint, ok := labels[ResetEndpointLabel]; ok {
+			if service != CurrentEnv().Service {
+				return hooks, fmt.Errorf("%s is declared on %s, only the service of the instance, %s, serves the reset endpoint", ResetEndpointLabel, service, CurrentEnv().Service)
+			}
+			hooks.Endpoint = endpoint
+		}
+		if command, ok := labels[ResetCommandLabel]; ok {
+			hooks.Commands[service] = command
+		}
+	}
+	return hooks, nil
+}
+
+// reset runs the hooks against the stack, every Describe gets the instance back as it started
+func (t *Target) reset(hooks ResetHooks) error {
+	ctx := context.Background()
+	for _, service := range slices.Sorted(maps.Keys(hooks.Commands)) {
+		container, err := t.Stack.ServiceContainer(ctx, service)
+		if err != nil {
+			return fmt.Errorf("Failed to find the container of %s %v", service, err)
+		}
+		code, output, err := container.Exec(ctx, []string{"sh", "-c", hooks.Commands[service]}, tcexec.Multiplexed())
+		if err != nil {
+			return fmt.Errorf("Failed to run the %s of %s %v", ResetCommandLabel, service, err)
+		}
+		if code != 0 {
+			out, _ := io.ReadAll(output)
+			return fmt.Errorf("The %s of %s exited with %d: %s", ResetCommandLabel, service, code, out)
+		}
+	}
+	if hooks.Endpoint == "" {
+		return nil
+	}
+	// Not targetClient, the reset is no exchange of the spec and stays out of its HAR
+	resp, err := http.DefaultClient.Post(t.URL+hooks.Endpoint, "", nil)
+	if err != nil {
+		return fmt.Errorf("Failed to call the %s %v", ResetEndpointLabel, err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("The %s %s responded with %d", ResetEndpointLabel, hooks.Endpoint, resp.StatusCode)
+	}
+	return nil
+}
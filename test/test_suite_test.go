@@ -9,5 +9,5 @@ import (
 
 func TestTest(t *testing.T) {
 	RegisterFailHandler(Fail)
-	RunSpecs(t, "DFMailbox compliance test suite")
+	RunSpecs(t, SuiteDescription)
 }
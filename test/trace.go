@@ -0,0 +1,424 @@
+package tests
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptrace"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	. "github.com/onsi/ginkgo/v2"
+	"github.com/onsi/ginkgo/v2/types"
+	. "github.com/onsi/gomega"
+)
+
+const exchangeEntry = "http exchange"
+
+// Exchange is a request the suite sent to an instance and what came back, recorded on the spec that sent it
+type Exchange struct {
+	Started        time.Time       `json:"started"`
+	Method         string          `json:"method"`
+	URL            string          `json:"url"`
+	Proto          string          `json:"proto"`
+	RequestHeader  http.Header     `json:"request_header"`
+	RequestBody    []byte          `json:"request_body,omitempty"`
+	Status         int             `json:"status,omitempty"`
+	StatusText     string          `json:"status_text,omitempty"`
+	ResponseProto  string          `json:"response_proto,omitempty"`
+	ResponseHeader http.Header     `json:"response_header,omitempty"`
+	ResponseBody   []byte          `json:"response_body,omitempty"`
+	Timings        ExchangeTimings `json:"timings"`
+	// Why no response came back
+	Error string `json:"error,omitempty"`
+}
+
+// ExchangeTimings splits an exchange into the phases HAR knows, a phase that didn't happen is -1
+type ExchangeTimings struct {
+	// Waiting for a connection, other than resolving and connecting
+	Blocked time.Duration `json:"blocked"`
+	DNS     time.Duration `json:"dns"`
+	Connect time.Duration `json:"connect"`
+	TLS     time.Duration `json:"tls"`
+	Send    time.Duration `json:"send"`
+	// Waiting for the first byte of the response
+	Wait    time.Duration `json:"wait"`
+	Receive time.Duration `json:"receive"`
+}
+
+func (t ExchangeTimings) Total() time.Duration {
+	var total time.Duration
+	for _, phase := range []time.Duration{t.Blocked, t.DNS, t.Connect, t.TLS, t.Send, t.Wait, t.Receive} {
+		if phase > 0 {
+			total += phase
+		}
+	}
+	return total
+}
+
+// String is the exchange as it went over the wire, less the headers the transport adds
+func (e Exchange) String() string {
+	var out strings.Builder
+	fmt.Fprintf(&out, "# %s, took %s\n", e.Started.Format(time.RFC3339Nano), e.Timings.Total())
+	fmt.Fprintf(&out, "%s %s %s\n", e.Method, e.URL, e.Proto)
+	writeHeader(&out, e.RequestHeader)
+	out.WriteString("\n")
+	if len(e.RequestBody) > 0 {
+		fmt.Fprintf(&out, "%s\n\n", e.RequestBody)
+	}
+	if e.Error != "" {
+		fmt.Fprintf(&out, "# Failed: %s\n", e.Error)
+		return out.String()
+	}
+	fmt.Fprintf(&out, "%s %d %s\n", e.ResponseProto, e.Status, e.StatusText)
+	writeHeader(&out, e.ResponseHeader)
+	out.WriteString("\n")
+	out.Write(e.ResponseBody)
+	return out.String()
+}
+
+func writeHeader(w io.Writer, header http.Header) {
+	for _, name := range sortedNames(header) {
+		for _, value := range header[name] {
+			fmt.Fprintf(w, "%s: %s\n", name, value)
+		}
+	}
+}
+
+// sortedNames lists the names of headers or query parameters in a stable order
+func sortedNames(values map[string][]string) []string {
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// tracer records every exchange on the running spec with its headers, bodies and timings
+type tracer struct {
+	next http.RoundTripper
+}
+
+func (t *tracer) RoundTrip(req *http.Request) (*http.Response, error) {
+	exchange := Exchange{
+		Started:       time.Now(),
+		Method:        req.Method,
+		URL:           req.URL.String(),
+		Proto:         req.Proto,
+		RequestHeader: req.Header.Clone(),
+	}
+	if req.Body != nil && req.Body != http.NoBody {
+		body, err := io.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		exchange.RequestBody = body
+		req = req.Clone(req.Context())
+		req.Body = io.NopCloser(bytes.NewReader(body))
+	}
+
+	var getConn, gotConn, dnsStart, dnsDone, connectStart, connectDone, tlsStart, tlsDone, wrote, firstByte time.Time
+	trace := &httptrace.ClientTrace{
+		GetConn:              func(string) { getConn = time.Now() },
+		GotConn:              func(httptrace.GotConnInfo) { gotConn = time.Now() },
+		DNSStart:             func(httptrace.DNSStartInfo) { dnsStart = time.Now() },
+		DNSDone:              func(httptrace.DNSDoneInfo) { dnsDone = time.Now() },
+		ConnectStart:         func(string, string) { connectStart = time.Now() },
+		ConnectDone:          func(string, string, error) { connectDone = time.Now() },
+		TLSHandshakeStart:    func() { tlsStart = time.Now() },
+		TLSHandshakeDone:     func(tls.ConnectionState, error) { tlsDone = time.Now() },
+		WroteRequest:         func(httptrace.WroteRequestInfo) { wrote = time.Now() },
+		GotFirstResponseByte: func() { firstByte = time.Now() },
+	}
+	req = req.WithContext(httptrace.WithClientTrace(req.Context(), trace))
+	resp, err := t.next.RoundTrip(req)
+	if err == nil {
+		var body []byte
+		body, err = io.ReadAll(resp.Body)
+		resp.Body.Close()
+		exchange.Status = resp.StatusCode
+		exchange.StatusText = http.StatusText(resp.StatusCode)
+		exchange.ResponseProto = resp.Proto
+		exchange.ResponseHeader = resp.Header.Clone()
+		exchange.ResponseBody = body
+	}
+	if err != nil {
+		// A response whose body couldn't be read is recorded but never handed back
+		exchange.Error = err.Error()
+		resp = nil
+	} else {
+		resp.Body = io.NopCloser(bytes.NewReader(exchange.ResponseBody))
+	}
+	done := time.Now()
+
+	phase := func(start time.Time, end time.Time) time.Duration {
+		if start.IsZero() || end.IsZero() {
+			return -1
+		}
+		return end.Sub(start)
+	}
+	timings := ExchangeTimings{
+		DNS:     phase(dnsStart, dnsDone),
+		Connect: phase(connectStart, connectDone),
+		TLS:     phase(tlsStart, tlsDone),
+		Send:    phase(gotConn, wrote),
+		Wait:    phase(wrote, firstByte),
+		Receive: phase(firstByte, done),
+	}
+	timings.Blocked = phase(getConn, gotConn) - max(timings.DNS, 0) - max(timings.Connect, 0) - max(timings.TLS, 0)
+	exchange.Timings = timings
+	AddReportEntry(exchangeEntry, exchange, ReportEntryVisibilityNever)
+	return resp, err
+}
+
+// targetClient is what every request to an instance under test goes through
+var targetClient = &http.Client{Transport: &tracer{next: http.DefaultTransport}}
+
+// ExchangesOf lists the exchanges a spec made, in the order they were sent
+func ExchangesOf(spec types.SpecReport) []Exchange {
+	out := []Exchange{}
+	for _, entry := range spec.ReportEntries {
+		if entry.Name != exchangeEntry {
+			continue
+		}
+		// The raw value doesn't survive a parallel run, the JSON does
+		exchange, ok := entry.GetRawValue().(Exchange)
+		if !ok && json.Unmarshal([]byte(entry.Value.AsJSON), &exchange) != nil {
+			continue
+		}
+		out = append(out, exchange)
+	}
+	return out
+}
+
+// HAR is the HTTP Archive 1.2 format, only the fields the suite fills in
+type HAR struct {
+	Log HARLog `json:"log"`
+}
+
+type HARLog struct {
+	Version string     `json:"version"`
+	Creator HARCreator `json:"creator"`
+	Pages   []HARPage  `json:"pages"`
+	Entries []HAREntry `json:"entries"`
+}
+
+type HARCreator struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+// HARPage is a spec, the entries of a page are the exchanges it made
+type HARPage struct {
+	StartedDateTime time.Time      `json:"startedDateTime"`
+	Id              string         `json:"id"`
+	Title           string         `json:"title"`
+	PageTimings     map[string]any `json:"pageTimings"`
+}
+
+type HAREntry struct {
+	Pageref         string         `json:"pageref"`
+	StartedDateTime time.Time      `json:"startedDateTime"`
+	Time            float64        `json:"time"`
+	Request         HARRequest     `json:"request"`
+	Response        HARResponse    `json:"response"`
+	Cache           map[string]any `json:"cache"`
+	Timings         HARTimings     `json:"timings"`
+	// Why no response came back, the status is 0 then
+	Comment string `json:"comment,omitempty"`
+}
+
+type HARRequest struct {
+	Method      string         `json:"method"`
+	URL         string         `json:"url"`
+	HTTPVersion string         `json:"httpVersion"`
+	Cookies     []HARNameValue `json:"cookies"`
+	Headers     []HARNameValue `json:"headers"`
+	QueryString []HARNameValue `json:"queryString"`
+	PostData    *HARPostData   `json:"postData,omitempty"`
+	HeadersSize int            `json:"headersSize"`
+	BodySize    int            `json:"bodySize"`
+}
+
+type HARResponse struct {
+	Status      int            `json:"status"`
+	StatusText  string         `json:"statusText"`
+	HTTPVersion string         `json:"httpVersion"`
+	Cookies     []HARNameValue `json:"cookies"`
+	Headers     []HARNameValue `json:"headers"`
+	Content     HARContent     `json:"content"`
+	RedirectURL string         `json:"redirectURL"`
+	HeadersSize int            `json:"headersSize"`
+	BodySize    int            `json:"bodySize"`
+}
+
+type HARNameValue struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type HARPostData struct {
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text"`
+}
+
+type HARContent struct {
+	Size     int    `json:"size"`
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text,omitempty"`
+	Encoding string `json:"encoding,omitempty"`
+}
+
+// HARTimings are in milliseconds, -1 for a phase that didn't happen
+type HARTimings struct {
+	Blocked float64 `json:"blocked"`
+	DNS     float64 `json:"dns"`
+	Connect float64 `json:"connect"`
+	Send    float64 `json:"send"`
+	Wait    float64 `json:"wait"`
+	Receive float64 `json:"receive"`
+	SSL     float64 `json:"ssl"`
+}
+
+// BuildHAR turns the exchanges of every spec that made any into a page of entries
+func BuildHAR(specs []types.SpecReport) HAR {
+	har := HAR{Log: HARLog{
+		Version: "1.2",
+		Creator: HARCreator{Name: "dfmc", Version: fmt.Sprint(ComplianceSchemaVersion)},
+		Pages:   []HARPage{},
+		Entries: []HAREntry{},
+	}}
+	for _, spec := range specs {
+		exchanges := ExchangesOf(spec)
+		if len(exchanges) == 0 {
+			continue
+		}
+		page := HARPage{
+			StartedDateTime: spec.StartTime,
+			Id:              fmt.Sprintf("spec_%d", len(har.Log.Pages)+1),
+			Title:           spec.FullText(),
+			PageTimings:     map[string]any{},
+		}
+		if id := RequirementOf(spec.Labels()); id != "" {
+			page.Title = id + " " + page.Title
+		}
+		har.Log.Pages = append(har.Log.Pages, page)
+		for _, exchange := range exchanges {
+			har.Log.Entries = append(har.Log.Entries, exchange.harEntry(page.Id))
+		}
+	}
+	return har
+}
+
+func (e Exchange) harEntry(pageref string) HAREntry {
+	entry := HAREntry{
+		Pageref:         pageref,
+		StartedDateTime: e.Started,
+		Time:            milliseconds(e.Timings.Total()),
+		Request: HARRequest{
+			Method:      e.Method,
+			URL:         e.URL,
+			HTTPVersion: e.Proto,
+			Cookies:     []HARNameValue{},
+			Headers:     harHeaders(e.RequestHeader),
+			QueryString: []HARNameValue{},
+			HeadersSize: -1,
+			BodySize:    len(e.RequestBody),
+		},
+		Response: HARResponse{
+			Status:      e.Status,
+			StatusText:  e.StatusText,
+			HTTPVersion: e.ResponseProto,
+			Cookies:     []HARNameValue{},
+			Headers:     harHeaders(e.ResponseHeader),
+			Content:     harContent(e.ResponseHeader, e.ResponseBody),
+			RedirectURL: e.ResponseHeader.Get("Location"),
+			HeadersSize: -1,
+			BodySize:    len(e.ResponseBody),
+		},
+		Cache: map[string]any{},
+		Timings: HARTimings{
+			Blocked: milliseconds(e.Timings.Blocked),
+			DNS:     milliseconds(e.Timings.DNS),
+			Connect: milliseconds(e.Timings.Connect),
+			Send:    milliseconds(e.Timings.Send),
+			Wait:    milliseconds(e.Timings.Wait),
+			Receive: milliseconds(e.Timings.Receive),
+			SSL:     milliseconds(e.Timings.TLS),
+		},
+		Comment: e.Error,
+	}
+	if parsed, err := url.Parse(e.URL); err == nil {
+		query := parsed.Query()
+		for _, name := range sortedNames(query) {
+			for _, value := range query[name] {
+				entry.Request.QueryString = append(entry.Request.QueryString, HARNameValue{Name: name, Value: value})
+			}
+		}
+	}
+	if len(e.RequestBody) > 0 {
+		entry.Request.PostData = &HARPostData{MimeType: e.RequestHeader.Get("Content-Type"), Text: string(e.RequestBody)}
+	}
+	if e.Timings.TLS > 0 {
+		// HAR counts the handshake as part of connecting
+		entry.Timings.Connect += entry.Timings.SSL
+	}
+	return entry
+}
+
+func harHeaders(header http.Header) []HARNameValue {
+	out := []HARNameValue{}
+	for _, name := range sortedNames(header) {
+		for _, value := range header[name] {
+			out = append(out, HARNameValue{Name: name, Value: value})
+		}
+	}
+	return out
+}
+
+func harContent(header http.Header, body []byte) HARContent {
+	content := HARContent{Size: len(body), MimeType: header.Get("Content-Type")}
+	if content.MimeType == "" {
+		content.MimeType = "application/octet-stream"
+	}
+	if utf8.Valid(body) {
+		content.Text = string(body)
+	} else {
+		content.Text = base64.StdEncoding.EncodeToString(body)
+		content.Encoding = "base64"
+	}
+	return content
+}
+
+func milliseconds(d time.Duration) float64 {
+	if d < 0 {
+		return -1
+	}
+	return float64(d) / float64(time.Millisecond)
+}
+
+func (h HAR) Write(path string) error {
+	data, err := json.MarshalIndent(h, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0o644)
+}
+
+var _ = ReportAfterSuite("http archive", func(report Report) {
+	path := CurrentEnv().HARPath
+	if report.SuiteConfig.DryRun || path == "" {
+		return
+	}
+	Expect(BuildHAR(report.SpecReports).Write(path)).Should(Succeed())
+})
@@ -0,0 +1,173 @@
+package tests
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	"github.com/onsi/ginkgo/v2/types"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Building the HTTP archive", Label("unit"), func() {
+	exchange := Exchange{
+		Started:       time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC),
+		Method:        "POST",
+		URL:           "http://localhost:8080/v0/mailbox/7?after=3&limit=10&limit=20",
+		Proto:         "HTTP/1.1",
+		RequestHeader: http.Header{"Content-Type": {"application/json"}},
+		RequestBody:   []byte(`{"data":1}`),
+		Status:        302,
+		StatusText:    "302 Found",
+		ResponseProto: "HTTP/1.1",
+		ResponseHeader: http.Header{
+			"Content-Type": {"application/json"},
+			"Location":     {"/v0/mailbox/7"},
+		},
+		ResponseBody: []byte(`{"id":4}`),
+		Timings: ExchangeTimings{
+			Blocked: -1,
+			DNS:     -1,
+			Connect: -1,
+			TLS:     -1,
+			Send:    500 * time.Microsecond,
+			Wait:    2 * time.Millisecond,
+			Receive: time.Millisecond,
+		},
+	}
+	entry := func(e Exchange) types.ReportEntry {
+		return types.ReportEntry{Name: exchangeEntry, Value: types.WrapEntryValue(e)}
+	}
+
+	It("should turn every spec that made exchanges into a page", func() {
+		// Reports from parallel processes only carry the JSON of an entry
+		parallel := entry(exchange)
+		data, err := json.Marshal(parallel.Value)
+		Expect(err).ShouldNot(HaveOccurred())
+		parallel.Value = types.ReportEntryValue{}
+		Expect(json.Unmarshal(data, &parallel.Value)).Should(Succeed())
+		Expect(parallel.GetRawValue()).ShouldNot(BeAssignableToTypeOf(Exchange{}))
+
+		har := BuildHAR([]types.SpecReport{
+			{LeafNodeType: types.NodeTypeIt, LeafNodeText: "first", LeafNodeLabels: []string{"req:A-001"}, ReportEntries: types.ReportEntries{entry(exchange)}},
+			{LeafNodeType: types.NodeTypeIt, LeafNodeText: "quiet"},
+			{LeafNodeType: types.NodeTypeIt, LeafNodeText: "second", ReportEntries: types.ReportEntries{parallel, entry(exchange)}},
+		})
+		Expect(har.Log.Version).Should(Equal("1.2"))
+		Expect(har.Log.Pages).Should(HaveExactElements(
+			SatisfyAll(HaveField("Id", "spec_1"), HaveField("Title", "A-001 first")),
+			SatisfyAll(HaveField("Id", "spec_2"), HaveField("Title", "second")),
+		))
+		Expect(har.Log.Entries).Should(HaveExactElements(
+			HaveField("Pageref", "spec_1"),
+			HaveField("Pageref", "spec_2"),
+			HaveField("Pageref", "spec_2"),
+		))
+		Expect(har.Log.Entries[1]).Should(Equal(har.Log.Entries[2]))
+	})
+	It("should mark the phases that didn't happen with -1", func() {
+		entry := exchange.harEntry("spec_1")
+		Expect(entry.Timings).Should(Equal(HARTimings{
+			Blocked: -1,
+			DNS:     -1,
+			Connect: -1,
+			SSL:     -1,
+			Send:    0.5,
+			Wait:    2,
+			Receive: 1,
+		}))
+		Expect(entry.Time).Should(Equal(3.5))
+	})
+	It("should count the handshake as part of connecting", func() {
+		tls := exchange
+		tls.Timings.Connect = 3 * time.Millisecond
+		tls.Timings.TLS = 2 * time.Millisecond
+		entry := tls.harEntry("spec_1")
+		Expect(entry.Timings.Connect).Should(Equal(5.0))
+		Expect(entry.Timings.SSL).Should(Equal(2.0))
+		Expect(entry.Time).Should(Equal(8.5))
+	})
+	It("should list the query string", func() {
+		Expect(exchange.harEntry("spec_1").Request.QueryString).Should(HaveExactElements(
+			HARNameValue{Name: "after", Value: "3"},
+			HARNameValue{Name: "limit", Value: "10"},
+			HARNameValue{Name: "limit", Value: "20"},
+		))
+	})
+	It("should keep the bodies and where the response redirects", func() {
+		entry := exchange.harEntry("spec_1")
+		Expect(entry.Request.PostData).Should(HaveValue(Equal(HARPostData{MimeType: "application/json", Text: `{"data":1}`})))
+		Expect(entry.Request.BodySize).Should(Equal(10))
+		Expect(entry.Response.Content).Should(Equal(HARContent{Size: 8, MimeType: "application/json", Text: `{"id":4}`}))
+		Expect(entry.Response.RedirectURL).Should(Equal("/v0/mailbox/7"))
+	})
+	It("should base64 encode a body that isn't text", func() {
+		body := []byte{0xff, 0xfe, 0x00, 0x01}
+		Expect(harContent(http.Header{}, body)).Should(Equal(HARContent{
+			Size:     4,
+			MimeType: "application/octet-stream",
+			Text:     base64.StdEncoding.EncodeToString(body),
+			Encoding: "base64",
+		}))
+	})
+	It("should say why no response came back", func() {
+		failed := exchange
+		failed.Status = 0
+		failed.StatusText = ""
+		failed.ResponseHeader = nil
+		failed.ResponseBody = nil
+		failed.Timings.Wait = -1
+		failed.Timings.Receive = -1
+		failed.Error = "connection refused"
+		entry := failed.harEntry("spec_1")
+		Expect(entry.Comment).Should(Equal("connection refused"))
+		Expect(entry.Response.Status).Should(BeZero())
+		Expect(entry.Response.Headers).Should(BeEmpty())
+		Expect(entry.Response.Content).Should(Equal(HARContent{MimeType: "application/octet-stream"}))
+		Expect(entry.Timings.Wait).Should(Equal(-1.0))
+		Expect(entry.Time).Should(Equal(0.5))
+	})
+})
+
+var _ = Describe("Tracing an exchange", Label("unit"), func() {
+	It("should not hand back a response whose body couldn't be read", func() {
+		body := &brokenBody{}
+		tracer := &tracer{next: roundTripper(func(req *http.Request) (*http.Response, error) {
+			return &http.Response{StatusCode: 200, Header: http.Header{}, Body: body, Request: req}, nil
+		})}
+		req, err := http.NewRequest("GET", "http://localhost:8080/v0/instance", nil)
+		Expect(err).ShouldNot(HaveOccurred())
+		resp, err := tracer.RoundTrip(req)
+		Expect(err).Should(MatchError(errBrokenBody))
+		Expect(resp).Should(BeNil())
+		Expect(body.closed).Should(BeTrue())
+		Expect(ExchangesOf(CurrentSpecReport())).Should(HaveExactElements(
+			SatisfyAll(HaveField("Status", 200), HaveField("Error", errBrokenBody.Error())),
+		))
+	})
+})
+
+type roundTripper func(*http.Request) (*http.Response, error)
+
+func (f roundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+var errBrokenBody = errors.New("connection reset")
+
+// brokenBody fails every read, the way a connection dropped mid-body does
+type brokenBody struct {
+	closed bool
+}
+
+func (b *brokenBody) Read([]byte) (int, error) {
+	return 0, errBrokenBody
+}
+
+func (b *brokenBody) Close() error {
+	b.closed = true
+	return nil
+}